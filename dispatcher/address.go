@@ -0,0 +1,187 @@
+package dispatcher
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// publishTimeout is how long an instance has to start running before its address is given up on.
+const publishTimeout = 10 * time.Minute
+const publishPollInterval = 5 * time.Second
+
+const addressUsage = "Expected: `>address eip eipalloc-id|none`, `>address dns factory.example.com|none` or `>address zone hosted-zone-id|none`"
+
+var addressRegexpDnsName = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]*[a-z0-9])?\.)+[a-z]{2,}$`)
+
+// serverAddress is the address players should use when the channel has a stable one, which the launcher then reports
+// instead of whatever the instance got.
+func (event messageEvent) serverAddress(guild *GuildStore, channel *ChannelStore) (string, error) {
+	if channel.DnsName != "" && guild.HostedZone != "" {
+		return channel.DnsName, nil
+	}
+	if channel.ElasticIP == "" {
+		return "", nil
+	}
+	client, err := newEc2client(event.ctx, guild)
+	if err != nil {
+		return "", err
+	}
+	output, err := client.DescribeAddresses(event.ctx, &ec2.DescribeAddressesInput{AllocationIds: []string{channel.ElasticIP}})
+	if err != nil {
+		return "", err
+	}
+	if len(output.Addresses) == 0 {
+		return "", fmt.Errorf("elastic IP %s not found", channel.ElasticIP)
+	}
+	return aws.ToString(output.Addresses[0].PublicIp), nil
+}
+
+// publishAddress gives the instance the channel's elastic IP and points its DNS name at it, once it runs. It goes on
+// after the command is done, since instances take a while to start.
+func (event messageEvent) publishAddress(guild *GuildStore, elasticIP, dnsName, instance string) {
+	runningHandlers.Add(1)
+	go func() {
+		defer runningHandlers.Done()
+		ctx, cancel := context.WithTimeout(botContext, publishTimeout)
+		defer cancel()
+		err := publishAddress(ctx, guild, elasticIP, dnsName, instance)
+		if err != nil {
+			log.Printf("Unable to publish the address of %s: %v", instance, err)
+			_, _ = event.session.ChannelMessageSend(event.message.ChannelID,
+				fmt.Sprintf(":warning: Unable to set up the server's address: %v", err))
+		}
+	}()
+}
+
+func publishAddress(ctx context.Context, guild *GuildStore, elasticIP, dnsName, instance string) error {
+	client, err := newEc2client(ctx, guild)
+	if err != nil {
+		return err
+	}
+	running, err := ec2waitRunning(ctx, client, instance)
+	if err != nil {
+		return err
+	}
+	ipv4 := aws.ToString(running.PublicIpAddress)
+	var ipv6 string
+	for _, networkInterface := range running.NetworkInterfaces {
+		for _, address := range networkInterface.Ipv6Addresses {
+			if ipv6 == "" {
+				ipv6 = aws.ToString(address.Ipv6Address)
+			}
+		}
+	}
+
+	if elasticIP != "" {
+		output, err := client.AssociateAddress(ctx, &ec2.AssociateAddressInput{
+			AllocationId:       &elasticIP,
+			InstanceId:         &instance,
+			AllowReassociation: aws.Bool(true), // from the channel's last instance, if it's still around
+		})
+		if err != nil {
+			return err
+		}
+		log.Printf("Associated %s to %s", elasticIP, instance)
+		addresses, err := client.DescribeAddresses(ctx, &ec2.DescribeAddressesInput{AllocationIds: []string{elasticIP}})
+		if err != nil {
+			return err
+		}
+		for _, address := range addresses.Addresses {
+			if aws.ToString(address.AssociationId) == aws.ToString(output.AssociationId) {
+				ipv4 = aws.ToString(address.PublicIp)
+			}
+		}
+	}
+
+	if dnsName == "" || guild.HostedZone == "" {
+		return nil
+	}
+	return route53upsert(ctx, guild, dnsName, ipv4, ipv6)
+}
+
+func ec2waitRunning(ctx context.Context, client ec2api, instance string) (types.Instance, error) {
+	for {
+		output, err := client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{InstanceIds: []string{instance}})
+		if err != nil {
+			return types.Instance{}, err
+		}
+		for _, reservation := range output.Reservations {
+			for _, found := range reservation.Instances {
+				switch found.State.Name {
+				case types.InstanceStateNameRunning:
+					return found, nil
+				case types.InstanceStateNamePending:
+				default:
+					return types.Instance{}, fmt.Errorf("instance %s is %s", instance, found.State.Name)
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return types.Instance{}, ctx.Err()
+		case <-time.After(publishPollInterval):
+		}
+	}
+}
+
+func (event messageEvent) commandAddress() error {
+	guild := store.guild(event.message.GuildID)
+	channel := store.channel(event.message.ChannelID)
+	if len(event.command) == 1 {
+		show := func(value string) string {
+			if value == "" {
+				return "none"
+			}
+			return value
+		}
+		return event.reply("```\n" + strings.Join([]string{
+			"eip: " + show(channel.ElasticIP),
+			"dns: " + show(channel.DnsName),
+			"zone: " + show(guild.HostedZone),
+		}, "\n") + "\n```")
+	}
+	user := store.user(event.message.Author.ID)
+	if !user.IsAdmin {
+		return errUnauthorized
+	}
+	if len(event.command) != 3 {
+		return event.reply(addressUsage)
+	}
+	value := event.command[2]
+	if value == "none" {
+		value = ""
+	}
+	switch event.command[1] {
+	case "eip":
+		if value != "" && !strings.HasPrefix(value, "eipalloc-") {
+			return event.reply(addressUsage)
+		}
+		storeLock.Lock()
+		channel.ElasticIP = value
+		storeLock.Unlock()
+	case "dns":
+		value = strings.TrimSuffix(strings.ToLower(value), ".")
+		if value != "" && !addressRegexpDnsName.MatchString(value) {
+			return event.reply(addressUsage)
+		}
+		storeLock.Lock()
+		channel.DnsName = value
+		storeLock.Unlock()
+	case "zone":
+		storeLock.Lock()
+		guild.HostedZone = strings.TrimPrefix(value, "/hostedzone/")
+		storeLock.Unlock()
+	default:
+		return event.reply(addressUsage)
+	}
+	store.store()
+	return event.react(":white_check_mark:")
+}
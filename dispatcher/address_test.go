@@ -0,0 +1,38 @@
+package dispatcher
+
+import (
+	"testing"
+
+	route53types "github.com/aws/aws-sdk-go-v2/service/route53/types"
+)
+
+func TestPlayWithStableAddress(t *testing.T) {
+	_, fakeEc2, session := setupFakes(t)
+	fakeEc2.Addresses["eipalloc-1"] = "198.51.100.7"
+	fakeRoute53 := useFakeRoute53(t)
+
+	sendMessage(t, session, ">aws us-east-1 "+testBucket)
+	sendMessage(t, session, ">address eip eipalloc-1")
+	sendMessage(t, session, ">address zone /hostedzone/Z0123")
+	sendMessage(t, session, ">address dns Factory.Example.com.")
+	sendMessage(t, session, ">setup factorio")
+	sendMessage(t, session, ">play")
+	runningHandlers.Wait()
+
+	if len(fakeEc2.UserData) != 1 {
+		t.Fatalf("started %d instances", len(fakeEc2.UserData))
+	}
+	if address := userDataVariables(fakeEc2.UserData[0])["SERVER_ADDRESS"]; address != "factory.example.com" {
+		t.Errorf("the launcher would say the address is %q", address)
+	}
+	instance := store.channel(testChannelID).Instance
+	if fakeEc2.Associations["eipalloc-1"] != instance {
+		t.Errorf("the elastic IP went to %q instead of %q", fakeEc2.Associations["eipalloc-1"], instance)
+	}
+	if record := fakeRoute53.Record("Z0123", "factory.example.com", route53types.RRTypeA); record != "198.51.100.7" {
+		t.Errorf("factory.example.com points at %q", record)
+	}
+	if store.guild(testGuildID).HostedZone != "Z0123" {
+		t.Errorf("hosted zone is %q", store.guild(testGuildID).HostedZone)
+	}
+}
@@ -5,110 +5,361 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"narval/launchers"
 	"os"
-	"strings"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
 )
 
-var s3alreadyUploadedSelf = map[string]bool{}
+const ec2instanceType = types.InstanceTypeC5aLarge
+
+// launcherUrlExpiry is how long an instance has to boot and download the launcher.
+const launcherUrlExpiry = 1 * time.Hour
+
 var errAmiNotFound = errors.New("AWS AMI Not Found")
 
-func s3upload(guild *GuildStore, key string, reader io.Reader) error {
-	ctx := context.TODO()
-	cfg, err := config.LoadDefaultConfig(ctx)
+// ec2image is a family of AMIs the bootstrap works on; the name has the architecture in it, which it calls amd64
+// unless told otherwise.
+type ec2image struct {
+	owner string
+	name  string
+	amd64 string
+}
+
+const defaultDistro = "amazon"
+
+var ec2images = map[string]ec2image{
+	"amazon": {"amazon", "al2023-ami-2023.*-kernel-*-%s", "x86_64"},
+	"debian": {"136693071363", "debian-11-%s-*", "amd64"},
+	"ubuntu": {"099720109477", "ubuntu/images/hvm-ssd/ubuntu-focal-20.04-%s-server-*", "amd64"},
+}
+
+// s3api and ec2api are the parts of the AWS clients the dispatcher uses, so tests can put fakes in their place.
+type s3api interface {
+	s3.ListObjectsV2APIClient
+	PutObject(context.Context, *s3.PutObjectInput, ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	GetObject(context.Context, *s3.GetObjectInput, ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	DeleteObject(context.Context, *s3.DeleteObjectInput, ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
+	HeadObject(context.Context, *s3.HeadObjectInput, ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+	PresignGetObject(context.Context, *s3.GetObjectInput, ...func(*s3.PresignOptions)) (*v4.PresignedHTTPRequest, error)
+}
+
+// presigningS3client is an S3 client that can also sign URLs for others to download with.
+type presigningS3client struct {
+	*s3.Client
+	presign *s3.PresignClient
+}
+
+func (client presigningS3client) PresignGetObject(ctx context.Context, input *s3.GetObjectInput, options ...func(*s3.PresignOptions)) (*v4.PresignedHTTPRequest, error) {
+	return client.presign.PresignGetObject(ctx, input, options...)
+}
+
+type ec2api interface {
+	RunInstances(context.Context, *ec2.RunInstancesInput, ...func(*ec2.Options)) (*ec2.RunInstancesOutput, error)
+	DescribeInstances(context.Context, *ec2.DescribeInstancesInput, ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error)
+	TerminateInstances(context.Context, *ec2.TerminateInstancesInput, ...func(*ec2.Options)) (*ec2.TerminateInstancesOutput, error)
+	DescribeImages(context.Context, *ec2.DescribeImagesInput, ...func(*ec2.Options)) (*ec2.DescribeImagesOutput, error)
+	DescribeAddresses(context.Context, *ec2.DescribeAddressesInput, ...func(*ec2.Options)) (*ec2.DescribeAddressesOutput, error)
+	AssociateAddress(context.Context, *ec2.AssociateAddressInput, ...func(*ec2.Options)) (*ec2.AssociateAddressOutput, error)
+}
+
+type route53api interface {
+	ChangeResourceRecordSets(context.Context, *route53.ChangeResourceRecordSetsInput, ...func(*route53.Options)) (*route53.ChangeResourceRecordSetsOutput, error)
+	ListResourceRecordSets(context.Context, *route53.ListResourceRecordSetsInput, ...func(*route53.Options)) (*route53.ListResourceRecordSetsOutput, error)
+}
+
+// awsClientKey tells cached clients apart; guilds in the same region with the same endpoint share them.
+type awsClientKey struct {
+	region   string
+	endpoint launchers.S3Endpoint
+}
+
+var awsConfig *aws.Config
+var s3clients = map[awsClientKey]presigningS3client{}
+var ec2clients = map[string]*ec2.Client{}
+var route53client *route53.Client
+var awsClientsLock sync.Mutex
+
+// loadAwsConfig finds the credentials once, since that can mean asking the instance metadata service; the caller
+// holds awsClientsLock.
+func loadAwsConfig(ctx context.Context) (aws.Config, error) {
+	if awsConfig == nil {
+		cfg, err := config.LoadDefaultConfig(ctx)
+		if err != nil {
+			return aws.Config{}, err
+		}
+		awsConfig = &cfg
+	}
+	return awsConfig.Copy(), nil
+}
+
+var newS3client = func(ctx context.Context, guild *GuildStore) (s3api, error) {
+	awsClientsLock.Lock()
+	defer awsClientsLock.Unlock()
+	key := awsClientKey{guild.Region, guild.S3Endpoint}
+	if client, found := s3clients[key]; found {
+		return client, nil
+	}
+	cfg, err := loadAwsConfig(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	cfg.Region = guild.Region
-	client := s3.NewFromConfig(cfg)
+	client := s3.NewFromConfig(cfg, guild.S3Endpoint.Apply)
+	s3clients[key] = presigningS3client{client, s3.NewPresignClient(client)}
+	return s3clients[key], nil
+}
+
+var newEc2client = func(ctx context.Context, guild *GuildStore) (ec2api, error) {
+	awsClientsLock.Lock()
+	defer awsClientsLock.Unlock()
+	if client, found := ec2clients[guild.Region]; found {
+		return client, nil
+	}
+	cfg, err := loadAwsConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Region = guild.Region
+	client := ec2.NewFromConfig(cfg)
+	ec2clients[guild.Region] = client
+	return client, nil
+}
+
+// newRoute53client makes the one client every guild shares, since Route 53 is global.
+var newRoute53client = func(ctx context.Context) (route53api, error) {
+	awsClientsLock.Lock()
+	defer awsClientsLock.Unlock()
+	if route53client != nil {
+		return route53client, nil
+	}
+	cfg, err := loadAwsConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	route53client = route53.NewFromConfig(cfg)
+	return route53client, nil
+}
+
+func s3upload(ctx context.Context, guild *GuildStore, key string, reader io.Reader) error {
+	client, err := newS3client(ctx, guild)
+	if err != nil {
+		return err
+	}
 	input := s3.PutObjectInput{Bucket: &guild.Bucket, Key: &key, Body: reader}
 	_, err = client.PutObject(ctx, &input)
 	return err
 }
 
-func s3uploadSelf(guild *GuildStore) error {
-	if s3alreadyUploadedSelf[guild.Bucket] {
-		return nil
+// s3download fetches an object, telling nil if there is no such object.
+func s3download(ctx context.Context, guild *GuildStore, key string) ([]byte, error) {
+	client, err := newS3client(ctx, guild)
+	if err != nil {
+		return nil, err
+	}
+	output, err := client.GetObject(ctx, &s3.GetObjectInput{Bucket: &guild.Bucket, Key: &key})
+	var noSuchKey *s3types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return nil, nil
 	}
-	var err error
-	path, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	defer launchers.CloseDontCare(output.Body)
+	return io.ReadAll(output.Body)
+}
+
+// s3list tells the keys of every object under a prefix.
+func s3list(ctx context.Context, guild *GuildStore, prefix string) ([]string, error) {
+	client, err := newS3client(ctx, guild)
+	if err != nil {
+		return nil, err
+	}
+	var result []string
+	paginator := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{Bucket: &guild.Bucket, Prefix: &prefix})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range page.Contents {
+			result = append(result, *object.Key)
+		}
+	}
+	return result, nil
+}
+
+// s3presignGet makes a URL anyone can download an object from for a while, without credentials.
+func s3presignGet(ctx context.Context, guild *GuildStore, key string, expires time.Duration) (string, error) {
+	client, err := newS3client(ctx, guild)
+	if err != nil {
+		return "", err
+	}
+	request, err := client.PresignGetObject(ctx, &s3.GetObjectInput{Bucket: &guild.Bucket, Key: &key},
+		s3.WithPresignExpires(expires))
+	if err != nil {
+		return "", err
+	}
+	return request.URL, nil
+}
+
+func s3delete(ctx context.Context, guild *GuildStore, key string) error {
+	client, err := newS3client(ctx, guild)
 	if err != nil {
 		return err
 	}
-	file, err := os.Open(path)
+	_, err = client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: &guild.Bucket, Key: &key})
+	return err
+}
+
+// s3uploadLauncher puts the build in the guild's bucket, unless that exact build is already there.
+func s3uploadLauncher(ctx context.Context, guild *GuildStore, build launcherBuild) error {
+	client, err := newS3client(ctx, guild)
 	if err != nil {
 		return err
 	}
-	err = s3upload(guild, "narval", file)
+	key := build.key()
+	_, err = client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: &guild.Bucket, Key: &key})
+	var notFound *s3types.NotFound
+	if !errors.As(err, &notFound) {
+		return err
+	}
+	file, err := os.Open(build.path)
 	if err != nil {
 		return err
 	}
-	s3alreadyUploadedSelf[guild.Bucket] = true
-	return nil
+	defer launchers.CloseDontCare(file)
+	_, err = client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:   &guild.Bucket,
+		Key:      &key,
+		Body:     file,
+		Metadata: map[string]string{launchers.Sha256MetadataKey: build.sha256},
+	})
+	return err
 }
 
-func ec2makeServer(guild *GuildStore, variables map[string]string) error {
-	ctx := context.TODO()
-	cfg, err := config.LoadDefaultConfig(ctx)
+// ec2provider runs each session on its own EC2 instance, which fetches the launcher from the guild's bucket.
+type ec2provider struct{}
+
+func (ec2provider) launch(ctx context.Context, guild *GuildStore, variables map[string]string) (string, error) {
+	arch := instanceArchitecture(string(ec2instanceType))
+	build, err := launcherBuildFor("linux", arch)
 	if err != nil {
-		return err
+		return "", err
+	}
+	if err = s3uploadLauncher(ctx, guild, build); err != nil {
+		return "", err
+	}
+	variables["LAUNCHER_URL"], err = s3presignGet(ctx, guild, build.key(), launcherUrlExpiry)
+	if err != nil {
+		return "", err
+	}
+	variables["LAUNCHER_SHA256"] = build.sha256
+	variables["AWS_REGION"] = guild.Region
+	client, err := newEc2client(ctx, guild)
+	if err != nil {
+		return "", err
 	}
-	cfg.Region = guild.Region
-	client := ec2.NewFromConfig(cfg)
 
 	// launch the instance :D
-	imageId, err := ec2getBestAmi(ctx, client)
+	imageId, err := ec2getBestAmi(ctx, client, guild.Distro, arch)
 	if err != nil {
-		return err
+		return "", err
 	}
 	input := ec2.RunInstancesInput{
-		ImageId:      imageId,
-		InstanceType: types.InstanceTypeC5aLarge,
-		UserData:     variablesToLauncherScript(variables),
+		ImageId:                           imageId,
+		InstanceType:                      ec2instanceType,
+		MinCount:                          aws.Int32(1),
+		MaxCount:                          aws.Int32(1),
+		InstanceInitiatedShutdownBehavior: types.ShutdownBehaviorTerminate,
+		UserData:                          variablesToLauncherScript(variables),
+	}
+	if variables["SPOT"] != "" {
+		input.InstanceMarketOptions = &types.InstanceMarketOptionsRequest{
+			MarketType: types.MarketTypeSpot,
+			SpotOptions: &types.SpotMarketOptions{
+				SpotInstanceType:             types.SpotInstanceTypeOneTime,
+				InstanceInterruptionBehavior: types.InstanceInterruptionBehaviorTerminate,
+			},
+		}
+	}
+	output, err := client.RunInstances(ctx, &input)
+	if err != nil {
+		return "", err
+	}
+	return *output.Instances[0].InstanceId, nil
+}
+
+func (ec2provider) status(ctx context.Context, guild *GuildStore, instance string) (string, error) {
+	client, err := newEc2client(ctx, guild)
+	if err != nil {
+		return "", err
+	}
+	output, err := client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{InstanceIds: []string{instance}})
+	if err != nil {
+		return "", err
+	}
+	for _, reservation := range output.Reservations {
+		for _, found := range reservation.Instances {
+			return string(found.State.Name), nil
+		}
 	}
-	_, err = client.RunInstances(ctx, &input)
+	return "gone", nil
+}
+
+// stop terminates the instance, which shuts it down cleanly first, so the launcher gets to save.
+func (ec2provider) stop(ctx context.Context, guild *GuildStore, instance string) error {
+	client, err := newEc2client(ctx, guild)
+	if err != nil {
+		return err
+	}
+	_, err = client.TerminateInstances(ctx, &ec2.TerminateInstancesInput{InstanceIds: []string{instance}})
 	return err
 }
 
-func ec2getBestAmi(ctx context.Context, client *ec2.Client) (*string, error) {
-	amiInput := ec2.DescribeImagesInput{Filters: []types.Filter{{
-		Name:   aws.String("name"),
-		Values: []string{"amzn2-ami-hvm-*-x86_64-gp2"},
-	}}}
+func (ec2provider) hourlyPrice(guild *GuildStore) (float64, error) {
+	return hourlyPrice(guild.Region, string(ec2instanceType))
+}
+
+func ec2getBestAmi(ctx context.Context, client ec2api, distro string, arch string) (*string, error) {
+	image, found := ec2images[distro]
+	if !found {
+		image = ec2images[defaultDistro]
+	}
+	imageArch := arch
+	if arch == "amd64" {
+		imageArch = image.amd64
+	}
+	amiInput := ec2.DescribeImagesInput{
+		Owners: []string{image.owner},
+		Filters: []types.Filter{{
+			Name:   aws.String("name"),
+			Values: []string{fmt.Sprintf(image.name, imageArch)},
+		}},
+	}
 	amiOutput, err := client.DescribeImages(ctx, &amiInput)
 	if err != nil {
 		return nil, err
 	}
-	var mostRecentName, mostRecentDate *string
+	var mostRecentId, mostRecentDate *string
 	mostRecentDate = aws.String("")
 	for _, image := range amiOutput.Images {
 		if *mostRecentDate < *image.CreationDate {
 			mostRecentDate = image.CreationDate
-			mostRecentName = image.Name
+			mostRecentId = image.ImageId
 		}
 	}
-	if mostRecentName == nil {
+	if mostRecentId == nil {
 		return nil, errAmiNotFound
 	}
-	return mostRecentName, nil
-}
-
-func variablesToLauncherScript(variables map[string]string) *string {
-	var builder strings.Builder
-	// aws requires the shebang line in the userdata to run
-	builder.WriteString("#!/bin/bash\n")
-	for name, value := range variables {
-		value = strings.ReplaceAll(value, "'", "'\\''")
-		builder.WriteString(fmt.Sprintf("export %s='%s'\n", name, value))
-	}
-	// start narval as common user
-	builder.WriteString("aws s3 cp s3://$BUCKET/narval /opt/narval\n")
-	builder.WriteString("chmod +x /opt/narval\n")
-	builder.WriteString("sudo -u ec2-user -i /opt/narval\n")
-	return aws.String(builder.String())
+	return mostRecentId, nil
 }
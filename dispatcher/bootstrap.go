@@ -0,0 +1,96 @@
+package dispatcher
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// launcherBootstrap sets up and runs the launcher once the instance boots, with the variables in /etc/narval/env. It
+// only needs bash, coreutils, systemd 232 or later and one of curl, wget or python3, so Amazon Linux 2023, Debian and
+// Ubuntu all do. The launcher's unit powers the instance off once it's done, so the user data doesn't wait for it.
+const launcherBootstrap = `. /etc/narval/env
+
+id narval >/dev/null 2>&1 || useradd --system --create-home --home-dir /var/lib/narval --shell /usr/sbin/nologin narval
+chown root:narval /etc/narval/env
+chmod 0640 /etc/narval/env
+
+fetch() {
+  if command -v curl >/dev/null; then
+    curl --fail --silent --show-error --location --retry 5 --output "$2" "$1"
+  elif command -v wget >/dev/null; then
+    wget --quiet --tries=5 --output-document="$2" "$1"
+  else
+    python3 -c 'import sys, urllib.request; urllib.request.urlretrieve(sys.argv[1], sys.argv[2])' "$1" "$2"
+  fi
+}
+
+# run nothing but the build that was asked for
+mkdir -p /opt/narval
+if ! fetch "$LAUNCHER_URL" /opt/narval/narval ||
+  ! echo "$LAUNCHER_SHA256  /opt/narval/narval" | sha256sum --check --status; then
+  echo 'narval: unable to get the launcher that was asked for' >&2
+  shutdown -h now
+  exit 1
+fi
+chmod 0755 /opt/narval/narval
+
+cat > /etc/systemd/system/narval.service <<'NARVAL_UNIT'
+[Unit]
+Description=narval launcher
+Wants=network-online.target
+After=network-online.target
+StartLimitIntervalSec=30min
+StartLimitBurst=3
+# the instance lives as long as the launcher, restarts included
+OnFailure=narval-poweroff.service
+
+[Service]
+User=narval
+WorkingDirectory=/var/lib/narval
+ExecStart=/bin/sh -c '. /etc/narval/env && exec /opt/narval/narval'
+ExecStopPost=+/bin/sh -c 'if [ "$SERVICE_RESULT" = success ]; then systemctl --no-block poweroff; fi'
+Restart=on-failure
+RestartSec=30
+# stopping, which is what terminating the instance does, lets the launcher save before the game goes too
+KillMode=mixed
+TimeoutStopSec=2min
+NARVAL_UNIT
+cat > /etc/systemd/system/narval-poweroff.service <<'NARVAL_UNIT'
+[Unit]
+Description=power off once the narval launcher gave up
+
+[Service]
+Type=oneshot
+ExecStart=/bin/systemctl --no-block poweroff
+NARVAL_UNIT
+systemctl daemon-reload
+systemctl start --no-block narval
+`
+
+// variablesToLauncherScript makes the user data of an instance: the variables go to a file only root and the
+// launcher can read, and the bootstrap does the rest.
+func variablesToLauncherScript(variables map[string]string) *string {
+	names := make([]string, 0, len(variables))
+	for name := range variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var builder strings.Builder
+	// aws requires the shebang line in the userdata to run
+	builder.WriteString("#!/bin/bash\n")
+	builder.WriteString("umask 0077\nmkdir -p /etc/narval\n")
+	builder.WriteString("cat > /etc/narval/env <<'NARVAL_ENV'\n")
+	for _, name := range names {
+		value := strings.ReplaceAll(variables[name], "'", "'\\''")
+		builder.WriteString(fmt.Sprintf("export %s='%s'\n", name, value))
+	}
+	builder.WriteString("NARVAL_ENV\n")
+	builder.WriteString("umask 0022\n")
+	builder.WriteString(launcherBootstrap)
+	return aws.String(base64.StdEncoding.EncodeToString([]byte(builder.String())))
+}
@@ -0,0 +1,58 @@
+package dispatcher
+
+import (
+	"encoding/base64"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// userDataVariables reads back the exports variablesToLauncherScript puts in the user data, quotes and all.
+func userDataVariables(script string) map[string]string {
+	result := map[string]string{}
+	for {
+		start := strings.Index(script, "\nexport ")
+		if start < 0 {
+			return result
+		}
+		script = script[start+len("\nexport "):]
+		separator := strings.Index(script, "='")
+		name := script[:separator]
+		script = script[separator+2:]
+		var value strings.Builder
+		for {
+			end := strings.Index(script, "'")
+			value.WriteString(script[:end])
+			script = script[end+1:]
+			if !strings.HasPrefix(script, `\''`) {
+				break
+			}
+			value.WriteString("'")
+			script = script[3:]
+		}
+		result[name] = value.String()
+	}
+}
+
+func TestUserDataIsValidBash(t *testing.T) {
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("no bash here")
+	}
+	script := filepath.Join(t.TempDir(), "user-data")
+	userData, _ := base64.StdEncoding.DecodeString(*variablesToLauncherScript(map[string]string{
+		"LAUNCH":       "factorio",
+		"PLAYER_LINKS": "alice=1\no'brien=2",
+	}))
+	if err = os.WriteFile(script, userData, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if output, err := exec.Command(bash, "-n", script).CombinedOutput(); err != nil {
+		t.Errorf("%v: %s", err, output)
+	}
+	if variables := userDataVariables(string(userData)); variables["PLAYER_LINKS"] != "alice=1\no'brien=2" {
+		t.Errorf("PLAYER_LINKS came out as %q", variables["PLAYER_LINKS"])
+	}
+}
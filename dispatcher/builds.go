@@ -0,0 +1,85 @@
+package dispatcher
+
+import (
+	"errors"
+	"fmt"
+	"narval/launchers"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// launcherBuild is an executable that can run as the launcher.
+type launcherBuild struct {
+	path   string
+	sha256 string
+	arch   string
+}
+
+// launcherHash remembers hashes by file, size and modification time, so replacing an artifact is noticed.
+type launcherHash struct {
+	path     string
+	size     int64
+	modified time.Time
+}
+
+var launcherHashes = map[launcherHash]string{}
+var launcherHashesLock sync.Mutex
+
+// Graviton families have a g right after the generation, like c6g or t4g; a1 came before them.
+var instanceRegexpArm = regexp.MustCompile(`^(a1|[a-z]+\d+g[a-z-]*)\.`)
+
+// instanceArchitecture tells the GOARCH a launcher needs to run on an instance type.
+func instanceArchitecture(instanceType string) string {
+	if instanceRegexpArm.MatchString(instanceType) {
+		return "arm64"
+	}
+	return "amd64"
+}
+
+// launcherBuildFor finds a launcher for a platform: a build in the LAUNCHER_ARTIFACTS folder named
+// narval-<GOOS>-<GOARCH>, or else the bot itself when it runs on the same kind of machine.
+func launcherBuildFor(goos, goarch string) (launcherBuild, error) {
+	name := fmt.Sprintf("narval-%s-%s", goos, goarch)
+	if folder := os.Getenv("LAUNCHER_ARTIFACTS"); folder != "" {
+		build, err := hashLauncher(filepath.Join(folder, name), goarch)
+		if !errors.Is(err, os.ErrNotExist) {
+			return build, err
+		}
+	}
+	if goos != runtime.GOOS || goarch != runtime.GOARCH {
+		return launcherBuild{}, fmt.Errorf("%w: put %s in LAUNCHER_ARTIFACTS (GOOS=%s GOARCH=%s go build)",
+			errNoLauncherBuild, name, goos, goarch)
+	}
+	path, err := os.Executable()
+	if err != nil {
+		return launcherBuild{}, err
+	}
+	return hashLauncher(path, goarch)
+}
+
+func hashLauncher(path, goarch string) (launcherBuild, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return launcherBuild{}, err
+	}
+	id := launcherHash{path, info.Size(), info.ModTime()}
+	launcherHashesLock.Lock()
+	defer launcherHashesLock.Unlock()
+	hash, found := launcherHashes[id]
+	if !found {
+		if hash, err = launchers.FileSha256(path); err != nil {
+			return launcherBuild{}, err
+		}
+		launcherHashes[id] = hash
+	}
+	return launcherBuild{path: path, sha256: hash, arch: goarch}, nil
+}
+
+// key is where the build lives in the bucket, so an instance always gets exactly the build that launched it.
+func (build launcherBuild) key() string {
+	return fmt.Sprintf("narval-%s-%s", build.sha256, build.arch)
+}
@@ -0,0 +1,31 @@
+package dispatcher
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestInstanceArchitecture(t *testing.T) {
+	for instanceType, expected := range map[string]string{
+		"c5a.large":   "amd64",
+		"t3.micro":    "amd64",
+		"g4dn.xlarge": "amd64",
+		"c6g.large":   "arm64",
+		"c6gn.xlarge": "arm64",
+		"t4g.medium":  "arm64",
+		"m7g.large":   "arm64",
+		"a1.large":    "arm64",
+	} {
+		if arch := instanceArchitecture(instanceType); arch != expected {
+			t.Errorf("%s is %s, expected %s", instanceType, arch, expected)
+		}
+	}
+}
+
+func TestLauncherBuildForMissingPlatform(t *testing.T) {
+	t.Setenv("LAUNCHER_ARTIFACTS", t.TempDir())
+	_, err := launcherBuildFor("plan9", "mips")
+	if !errors.Is(err, errNoLauncherBuild) {
+		t.Errorf("expected errNoLauncherBuild, got %v", err)
+	}
+}
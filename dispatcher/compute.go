@@ -0,0 +1,286 @@
+package dispatcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// computeProvider is somewhere the launcher can run. Instances are whatever string the provider uses to find them
+// again, and status is the provider's own word for their state.
+type computeProvider interface {
+	launch(ctx context.Context, guild *GuildStore, variables map[string]string) (string, error)
+	status(ctx context.Context, guild *GuildStore, instance string) (string, error)
+	stop(ctx context.Context, guild *GuildStore, instance string) error
+	hourlyPrice(guild *GuildStore) (float64, error)
+}
+
+// localProvider runs the launcher as a child process of the bot, each channel in its own folder under LOCAL_ROOT.
+type localProvider struct{}
+
+// dockerProvider runs the launcher in a container of DOCKER_IMAGE, with the bot's own executable mounted in.
+type dockerProvider struct{}
+
+var allProviders = map[string]computeProvider{
+	"ec2":    ec2provider{},
+	"local":  localProvider{},
+	"docker": dockerProvider{},
+}
+
+// localProcess is a launcher started by the local provider; exited is closed once it's done.
+type localProcess struct {
+	command *exec.Cmd
+	exited  chan struct{}
+}
+
+var localProcesses = map[string]localProcess{}
+var localProcessesLock sync.Mutex
+
+// localTempFolder is where in its folder a launcher on the host keeps temporary files.
+const localTempFolder = "tmp"
+
+const defaultDockerImage = "gcr.io/distroless/base-debian11"
+
+// dockerFirstPort is the host port of the first channel on docker; the others get the next free ones.
+const dockerFirstPort = 34197
+
+// dockerStopTimeout is how many seconds docker stop gives the launcher to save before killing it.
+const dockerStopTimeout = "120"
+
+func providerNamed(name string) computeProvider {
+	if provider, found := allProviders[name]; found {
+		return provider
+	}
+	return ec2provider{}
+}
+
+// localVariables adds what a launcher away from EC2 can't find out by itself, and a temporary folder of its own so
+// launchers sharing the host don't step on each other's downloads and logs.
+func localVariables(guild *GuildStore, variables map[string]string, tmp string) []string {
+	result := []string{"AWS_REGION=" + guild.Region, "TMPDIR=" + tmp}
+	for name, value := range variables {
+		result = append(result, name+"="+value)
+	}
+	return result
+}
+
+func localFolder(variables map[string]string) (string, error) {
+	root := os.Getenv("LOCAL_ROOT")
+	if root == "" {
+		root = filepath.Join(os.TempDir(), "narval")
+	}
+	// Keep the game and its state around between sessions of the same channel, like nothing else would
+	folder, err := filepath.Abs(filepath.Join(root, strings.TrimSuffix(variables["PREFIX"], "/")))
+	if err != nil {
+		return "", err
+	}
+	return folder, os.MkdirAll(filepath.Join(folder, localTempFolder), 0755)
+}
+
+func (localProvider) launch(_ context.Context, guild *GuildStore, variables map[string]string) (string, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	folder, err := localFolder(variables)
+	if err != nil {
+		return "", err
+	}
+	command := exec.Command(executable)
+	command.Dir = folder
+	command.Env = append(os.Environ(), localVariables(guild, variables, filepath.Join(folder, localTempFolder))...)
+	command.Stdout = os.Stdout
+	command.Stderr = os.Stderr
+	command.SysProcAttr = &syscall.SysProcAttr{Setpgid: true} // so a Ctrl-C meant for the bot doesn't cut the save
+	if err = command.Start(); err != nil {
+		return "", err
+	}
+
+	instance := fmt.Sprintf("local-%d", command.Process.Pid)
+	process := localProcess{command, make(chan struct{})}
+	localProcessesLock.Lock()
+	localProcesses[instance] = process
+	localProcessesLock.Unlock()
+	go func() {
+		_ = command.Wait()
+		close(process.exited)
+	}()
+	return instance, nil
+}
+
+func (process localProcess) hasExited() bool {
+	select {
+	case <-process.exited:
+		return true
+	default:
+		return false
+	}
+}
+
+func (localProvider) status(_ context.Context, _ *GuildStore, instance string) (string, error) {
+	localProcessesLock.Lock()
+	process, found := localProcesses[instance]
+	localProcessesLock.Unlock()
+	switch {
+	case !found:
+		return "gone", nil
+	case process.hasExited():
+		return "exited", nil
+	}
+	return "running", nil
+}
+
+func (localProvider) stop(_ context.Context, _ *GuildStore, instance string) error {
+	localProcessesLock.Lock()
+	process, found := localProcesses[instance]
+	localProcessesLock.Unlock()
+	if !found || process.hasExited() {
+		return nil
+	}
+	// The launcher saves and stops the game itself
+	return process.command.Process.Signal(syscall.SIGTERM)
+}
+
+func (localProvider) hourlyPrice(*GuildStore) (float64, error) {
+	return 0, nil
+}
+
+func docker(ctx context.Context, arguments ...string) (string, error) {
+	output, err := exec.CommandContext(ctx, "docker", arguments...).Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return "", fmt.Errorf("docker %s: %s", arguments[0], strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	return strings.TrimSpace(string(output)), err
+}
+
+func (dockerProvider) launch(ctx context.Context, guild *GuildStore, variables map[string]string) (string, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	folder, err := localFolder(variables)
+	if err != nil {
+		return "", err
+	}
+	image := os.Getenv("DOCKER_IMAGE")
+	if image == "" {
+		image = defaultDockerImage
+	}
+	arguments := []string{"run", "--detach", "--rm",
+		"--publish", variables["SERVER_PORT"] + ":34197/udp",
+		"--volume", executable + ":/opt/narval:ro",
+		"--volume", folder + ":/opt/game",
+		"--workdir", "/opt/game",
+	}
+	for _, name := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN"} {
+		if os.Getenv(name) != "" {
+			arguments = append(arguments, "--env", name) // passed through without showing up in ps
+		}
+	}
+	for _, variable := range localVariables(guild, variables, "/opt/game/"+localTempFolder) {
+		arguments = append(arguments, "--env", variable)
+	}
+	arguments = append(arguments, image, "/opt/narval")
+	return docker(ctx, arguments...)
+}
+
+func (dockerProvider) status(ctx context.Context, _ *GuildStore, instance string) (string, error) {
+	status, err := docker(ctx, "inspect", "--format", "{{.State.Status}}", instance)
+	if err != nil && strings.Contains(err.Error(), "No such object") {
+		return "gone", nil
+	}
+	return status, err
+}
+
+func (dockerProvider) stop(ctx context.Context, _ *GuildStore, instance string) error {
+	_, err := docker(ctx, "stop", "--time", dockerStopTimeout, instance)
+	return err
+}
+
+func (dockerProvider) hourlyPrice(*GuildStore) (float64, error) {
+	return 0, nil
+}
+
+func (event messageEvent) commandProvider() error {
+	guild := store.guild(event.message.GuildID)
+	if len(event.command) == 1 {
+		name := guild.Provider
+		if name == "" {
+			name = "ec2"
+		}
+		return event.reply(fmt.Sprintf("Servers run on `%s`.", name))
+	}
+	user := store.user(event.message.Author.ID)
+	if !user.IsAdmin {
+		return errUnauthorized
+	}
+	if _, found := allProviders[event.command[1]]; len(event.command) != 2 || !found {
+		return event.reply("Expected: `>provider ec2|local|docker`")
+	}
+	storeLock.Lock()
+	guild.Provider = event.command[1]
+	storeLock.Unlock()
+	store.store()
+	return event.react(":white_check_mark:")
+}
+
+// commandDistro picks which AMIs EC2 instances boot from.
+func (event messageEvent) commandDistro() error {
+	guild := store.guild(event.message.GuildID)
+	if len(event.command) == 1 {
+		name := guild.Distro
+		if name == "" {
+			name = defaultDistro
+		}
+		return event.reply(fmt.Sprintf("EC2 instances run `%s`.", name))
+	}
+	user := store.user(event.message.Author.ID)
+	if !user.IsAdmin {
+		return errUnauthorized
+	}
+	if _, found := ec2images[event.command[1]]; len(event.command) != 2 || !found {
+		return event.reply("Expected: `>distro amazon|debian|ubuntu`")
+	}
+	storeLock.Lock()
+	guild.Distro = event.command[1]
+	storeLock.Unlock()
+	store.store()
+	return event.react(":white_check_mark:")
+}
+
+func (event messageEvent) commandStatus() error {
+	guild := store.guild(event.message.GuildID)
+	channel := store.channel(event.message.ChannelID)
+	if channel.Instance == "" {
+		return event.reply("Nothing was started here.")
+	}
+	status, err := providerNamed(channel.InstanceProvider).status(event.ctx, guild, channel.Instance)
+	if err != nil {
+		return err
+	}
+	return event.reply(fmt.Sprintf("`%s` is %s.", channel.Instance, status))
+}
+
+func (event messageEvent) commandStop() error {
+	user := store.user(event.message.Author.ID)
+	if !user.IsAdmin {
+		return errUnauthorized
+	}
+	guild := store.guild(event.message.GuildID)
+	channel := store.channel(event.message.ChannelID)
+	if channel.Instance == "" {
+		return event.reply("Nothing was started here.")
+	}
+	err := providerNamed(channel.InstanceProvider).stop(event.ctx, guild, channel.Instance)
+	if err != nil {
+		return err
+	}
+	return event.react(":octagonal_sign:")
+}
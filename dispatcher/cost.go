@@ -0,0 +1,300 @@
+package dispatcher
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"narval/launchers"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SessionCost is what one >play spent, or is spending while Ended is zero.
+type SessionCost struct {
+	Channel      Snowflake
+	Session      string
+	Region       string
+	InstanceType string
+	HourlyPrice  float64
+	MaxSession   time.Duration
+	Started      time.Time
+	Ended        time.Time
+}
+
+// prices are on-demand USD per hour by region and instance type. The bundled table can be replaced without a new
+// build by pointing PRICES_FILE at a file with the same layout.
+var prices map[string]map[string]float64
+
+//go:embed prices.json
+var bundledPrices []byte
+
+func loadPrices() error {
+	buffer := bundledPrices
+	if pricesFile := os.Getenv("PRICES_FILE"); pricesFile != "" {
+		var err error
+		buffer, err = os.ReadFile(pricesFile)
+		if err != nil {
+			return err
+		}
+	}
+	return json.Unmarshal(buffer, &prices)
+}
+
+func hourlyPrice(region, instanceType string) (float64, error) {
+	price, found := prices[region][instanceType]
+	if !found {
+		return 0, fmt.Errorf("%w: %s in %s", errUnknownPrice, instanceType, region)
+	}
+	return price, nil
+}
+
+func (session SessionCost) Cost(now time.Time) float64 {
+	end := session.Ended
+	if end.IsZero() {
+		end = now
+	}
+	return end.Sub(session.Started).Hours() * session.HourlyPrice
+}
+
+// longest is how long the session was allowed to last; sessions tracked before that was kept got the default.
+func (session SessionCost) longest() time.Duration {
+	if session.MaxSession > 0 {
+		return session.MaxSession
+	}
+	return launchers.DefaultMaxSession
+}
+
+// settleSessions finds out when sessions ended from the summaries the launcher leaves behind. A session that never
+// left one is assumed to have lasted as long as it could.
+func (guild *GuildStore) settleSessions(ctx context.Context, now time.Time) {
+	// Copied out, so the lock isn't held while asking S3
+	var unsettled []SessionCost
+	storeLock.Lock()
+	for _, session := range guild.Sessions {
+		if session.Ended.IsZero() {
+			unsettled = append(unsettled, session)
+		}
+	}
+	storeLock.Unlock()
+
+	ended := map[string]time.Time{}
+	for _, session := range unsettled {
+		key := path.Join(session.Channel.String(), "stats", session.Session+".json")
+		buffer, err := s3download(ctx, guild, key)
+		if err != nil {
+			log.Printf("Unable to settle session %s: %v", session.Session, err)
+			continue
+		}
+		var summary launchers.SessionSummary
+		if buffer != nil && json.Unmarshal(buffer, &summary) == nil && !summary.End.IsZero() {
+			ended[session.Session] = summary.End
+		} else if longest := session.Started.Add(session.longest() + time.Hour); now.After(longest) {
+			ended[session.Session] = longest
+		}
+	}
+	if len(ended) == 0 {
+		return
+	}
+	storeLock.Lock()
+	for i := range guild.Sessions {
+		if end, found := ended[guild.Sessions[i].Session]; found && guild.Sessions[i].Ended.IsZero() {
+			guild.Sessions[i].Ended = end
+		}
+	}
+	storeLock.Unlock()
+	store.store()
+}
+
+// monthToDate adds up what sessions started this month cost, in total and by channel.
+func (guild *GuildStore) monthToDate(now time.Time) (float64, map[Snowflake]float64) {
+	now = now.UTC()
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	total := 0.0
+	byChannel := map[Snowflake]float64{}
+	storeLock.Lock()
+	defer storeLock.Unlock()
+	for _, session := range guild.Sessions {
+		if session.Started.Before(monthStart) {
+			continue
+		}
+		cost := session.Cost(now)
+		total += cost
+		byChannel[session.Channel] += cost
+	}
+	return total, byChannel
+}
+
+// budgetMaxSession tells how long a new session can run before the guild goes over its monthly budget, or zero when
+// there is no budget.
+func (guild *GuildStore) budgetMaxSession(ctx context.Context, hourly float64, now time.Time) (time.Duration, error) {
+	storeLock.Lock()
+	budget := guild.MonthlyBudget
+	storeLock.Unlock()
+	if budget <= 0 {
+		return 0, nil
+	}
+	guild.settleSessions(ctx, now)
+	spent, _ := guild.monthToDate(now)
+	if spent >= budget {
+		return 0, fmt.Errorf("%w: spent $%.2f of $%.2f", errOverBudget, spent, budget)
+	}
+	return time.Duration((budget - spent) / hourly * float64(time.Hour)), nil
+}
+
+// launch starts the server within the guild's budget, shortening the session if needed, and keeps track of its cost.
+func (event messageEvent) launch(guild *GuildStore, variables map[string]string) error {
+	now := time.Now()
+	provider := providerNamed(guild.Provider)
+	channel := store.channel(event.message.ChannelID)
+	_, onEc2 := provider.(ec2provider)
+	storeLock.Lock()
+	spot := onEc2 && channel.Spot && !channel.onDemand
+	storeLock.Unlock()
+	// Like the launcher, fall back to the default rather than run without a limit
+	maxSession, err := time.ParseDuration(variables["MAX_SESSION"])
+	if err != nil || maxSession <= 0 {
+		maxSession = launchers.DefaultMaxSession
+	}
+	hourly, err := provider.hourlyPrice(guild)
+	if err != nil && guild.MonthlyBudget > 0 {
+		return err
+	}
+	if err != nil {
+		log.Printf("Not tracking the cost: %v", err)
+	} else if hourly > 0 {
+		affordable, err := guild.budgetMaxSession(event.ctx, hourly, now)
+		if errors.Is(err, errOverBudget) {
+			return event.reply(fmt.Sprintf("Can't play; %v this month.", err))
+		}
+		if err != nil {
+			return err
+		}
+		if affordable > 0 && affordable < maxSession {
+			affordable = affordable.Truncate(time.Minute)
+			if affordable < time.Minute {
+				return event.reply("Can't play; the budget for this month is as good as spent.")
+			}
+			maxSession = affordable
+			variables["MAX_SESSION"] = affordable.String()
+			message := fmt.Sprintf("The budget only allows %v more this month, so that's how long this can last.",
+				affordable)
+			if spot {
+				message += " That's counting the spot instance at the on-demand price, which it costs at most."
+			}
+			if err = event.reply(message); err != nil {
+				return err
+			}
+		}
+	}
+
+	if onEc2 {
+		address, err := event.serverAddress(guild, channel)
+		if err != nil {
+			return err
+		}
+		if address != "" {
+			variables["SERVER_ADDRESS"] = address
+		}
+		if spot {
+			variables["SPOT"] = "true"
+		}
+	}
+	if _, onDocker := provider.(dockerProvider); onDocker {
+		variables["SERVER_PORT"] = strconv.Itoa(store.dockerPort(channel))
+		store.store()
+	}
+	instance, err := provider.launch(event.ctx, guild, variables)
+	if err != nil {
+		return err
+	}
+	if onEc2 && (channel.ElasticIP != "" || channel.DnsName != "") {
+		event.publishAddress(guild, channel.ElasticIP, channel.DnsName, instance)
+	}
+	storeLock.Lock()
+	channel.Instance = instance
+	channel.InstanceProvider = guild.Provider
+	if hourly > 0 {
+		guild.Sessions = append(guild.Sessions, SessionCost{
+			Channel:      sf(event.message.ChannelID),
+			Session:      variables["SESSION"],
+			Region:       guild.Region,
+			InstanceType: string(ec2instanceType),
+			HourlyPrice:  hourly,
+			MaxSession:   maxSession,
+			Started:      now,
+		})
+	}
+	storeLock.Unlock()
+	store.store()
+	return nil
+}
+
+func (event messageEvent) commandCost() error {
+	guild := store.guild(event.message.GuildID)
+	if len(event.command) > 1 {
+		return event.commandCostBudget(guild)
+	}
+	now := time.Now()
+	guild.settleSessions(event.ctx, now)
+	total, byChannel := guild.monthToDate(now)
+
+	storeLock.Lock()
+	budget := guild.MonthlyBudget
+	var running []SessionCost
+	for _, session := range guild.Sessions {
+		if session.Ended.IsZero() {
+			running = append(running, session)
+		}
+	}
+	storeLock.Unlock()
+
+	lines := []string{fmt.Sprintf("Spent $%.2f in %s so far.", total, now.UTC().Format("January"))}
+	if budget > 0 {
+		lines[0] = fmt.Sprintf("Spent $%.2f of the $%.2f budget in %s so far.",
+			total, budget, now.UTC().Format("January"))
+	}
+	channels := make([]Snowflake, 0, len(byChannel))
+	for channel := range byChannel {
+		channels = append(channels, channel)
+	}
+	sort.Slice(channels, func(i, j int) bool { return byChannel[channels[i]] > byChannel[channels[j]] })
+	for _, channel := range channels {
+		lines = append(lines, fmt.Sprintf("<#%s>: $%.2f", channel, byChannel[channel]))
+	}
+	for _, session := range running {
+		lines = append(lines, fmt.Sprintf("<#%s> is running since %s, at $%.3f an hour.",
+			session.Channel, session.Started.UTC().Format("15:04 MST"), session.HourlyPrice))
+	}
+	lines = append(lines, "Only counts the instances, spot ones at the on-demand price; storage and traffic are extra.")
+	return event.reply(strings.Join(lines, "\n"))
+}
+
+func (event messageEvent) commandCostBudget(guild *GuildStore) error {
+	user := store.user(event.message.Author.ID)
+	if !user.IsAdmin {
+		return errUnauthorized
+	}
+	if len(event.command) != 3 || event.command[1] != "budget" {
+		return event.reply("Expected: `>cost budget dollars|none`")
+	}
+	budget := 0.0
+	if event.command[2] != "none" {
+		var err error
+		budget, err = strconv.ParseFloat(strings.TrimPrefix(event.command[2], "$"), 64)
+		if err != nil || budget <= 0 {
+			return event.reply("Expected: `>cost budget dollars|none`")
+		}
+	}
+	storeLock.Lock()
+	guild.MonthlyBudget = budget
+	storeLock.Unlock()
+	store.store()
+	return event.react(":white_check_mark:")
+}
@@ -0,0 +1,30 @@
+package dispatcher
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSettleSessionsWithoutSummary(t *testing.T) {
+	setupFakes(t)
+	now := time.Date(2021, time.June, 10, 12, 0, 0, 0, time.UTC)
+	guild := store.guild(testGuildID)
+	guild.Region, guild.Bucket = "us-east-1", testBucket
+	guild.Sessions = []SessionCost{
+		{Channel: sf(testChannelID), Session: "long", MaxSession: 48 * time.Hour, Started: now.Add(-30 * time.Hour)},
+		{Channel: sf(testChannelID), Session: "short", MaxSession: 2 * time.Hour, Started: now.Add(-30 * time.Hour)},
+		{Channel: sf(testChannelID), Session: "old", Started: now.Add(-30 * time.Hour)},
+	}
+	guild.settleSessions(context.Background(), now)
+
+	if ended := guild.Sessions[0].Ended; !ended.IsZero() {
+		t.Errorf("a 48h session settled at %v after 30h", ended)
+	}
+	if ended, expected := guild.Sessions[1].Ended, now.Add(-27*time.Hour); !ended.Equal(expected) {
+		t.Errorf("a 2h session settled at %v, expected %v", ended, expected)
+	}
+	if ended, expected := guild.Sessions[2].Ended, now.Add(-5*time.Hour); !ended.Equal(expected) {
+		t.Errorf("a session without a max settled at %v, expected %v", ended, expected)
+	}
+}
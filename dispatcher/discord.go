@@ -1,32 +1,62 @@
 package dispatcher
 
 import (
+	"context"
 	cryptoRand "crypto/rand"
 	"encoding/base64"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"github.com/bwmarrin/discordgo"
 	"io"
 	"log"
 	"math/rand"
 	"narval/launchers"
+	"net/http"
 	"os"
 	"os/signal"
 	"path"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 )
 
 type messageEvent struct {
-	session *discordgo.Session
+	ctx     context.Context
+	session discordSession
 	message *discordgo.MessageCreate
 	command []string
 }
 
+// discordSession is the part of discordgo.Session the commands use, so tests can put a fake in its place.
+type discordSession interface {
+	ChannelMessageSend(channelID string, content string) (*discordgo.Message, error)
+	ChannelMessageSendReply(channelID string, content string, reference *discordgo.MessageReference) (*discordgo.Message, error)
+	ChannelMessageSendComplex(channelID string, data *discordgo.MessageSend) (*discordgo.Message, error)
+	ChannelMessageDelete(channelID, messageID string) error
+	ChannelTyping(channelID string) error
+	MessageReactionAdd(channelID, messageID, emojiID string) error
+	WebhookCreate(channelID, name, avatar string) (*discordgo.Webhook, error)
+	GuildMember(guildID, userID string) (*discordgo.Member, error)
+	Channel(channelID string) (*discordgo.Channel, error)
+}
+
+// commandTimeout is how long a command can take before whatever it waits on is given up.
+const commandTimeout = 5 * time.Minute
+
+// typingDelay is how long a command can take before the bot shows it's typing, which Discord shows for 10 seconds.
+const typingDelay = 1 * time.Second
+const typingInterval = 8 * time.Second
+
+// botContext is cancelled when the bot is told to exit, so commands in progress stop waiting on AWS.
+var botContext, stopBot = context.WithCancel(context.Background())
+var runningHandlers sync.WaitGroup
+
 type dispatcher interface {
 	setup(messageEvent) error
 	play(messageEvent) error
+	upload(messageEvent, *discordgo.MessageAttachment) error
 }
 
 func RunDispatcher() {
@@ -34,6 +64,10 @@ func RunDispatcher() {
 	if err != nil {
 		log.Panic(err)
 	}
+	err = loadPrices()
+	if err != nil {
+		log.Panic(err)
+	}
 
 	discord, err := discordgo.New("Bot " + os.Getenv("DISCORD_TOKEN"))
 	if err != nil {
@@ -47,12 +81,15 @@ func RunDispatcher() {
 		log.Panic(err)
 	}
 	defer launchers.CloseDontCare(discord)
+	go runScheduler(discord)
 
 	// We are done; just wait for exit
 	fmt.Println("Bot is running. Ctrl-C to exit.")
 	signalsChannel := make(chan os.Signal, 1)
 	signal.Notify(signalsChannel, syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
 	<-signalsChannel
+	stopBot()
+	runningHandlers.Wait()
 }
 
 func init() {
@@ -78,25 +115,82 @@ func randString() string {
 }
 
 func messageCreate(session *discordgo.Session, message *discordgo.MessageCreate) {
-	if message.Author.ID == session.State.User.ID { // self messages
+	handleMessage(session, session.State.User.ID, message)
+}
+
+func handleMessage(session discordSession, selfID string, message *discordgo.MessageCreate) {
+	if message.Author.ID == selfID { // self messages
 		return
 	}
+	runningHandlers.Add(1)
+	defer runningHandlers.Done()
+	ctx, cancel := context.WithTimeout(botContext, commandTimeout)
+	defer cancel()
 
-	if len(message.Attachments) > 0 {
-		for _, v := range message.Attachments {
-			log.Printf("%v", v)
-		}
+	var err error
+	event := messageEvent{ctx, session, message, nil}
+	if message.WebhookID != "" {
+		err = event.webhookMessage()
+	} else if len(message.Attachments) > 0 {
+		stopTyping := event.keepTyping()
+		err = event.attachments()
+		stopTyping()
 	} else if len(message.Content) > 1 && message.Content[:1] == ">" {
-		command := strings.Split(message.Content[1:], " ")
-		event := messageEvent{session, message, command}
-		err := event.commands()
-		if err == errUnauthorized {
-			_ = event.react(":unamused:")
-		} else if err != nil {
-			log.Printf("Message errored out: %s", err)
-			_ = event.react(":warning:")
+		event.command = strings.Split(message.Content[1:], " ")
+		stopTyping := event.keepTyping()
+		err = event.commands()
+		stopTyping()
+	}
+	if err == errUnauthorized {
+		_ = event.react(":unamused:")
+	} else if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		log.Printf("Message gave up: %s", err)
+		_ = event.react(":hourglass:")
+	} else if err != nil {
+		log.Printf("Message errored out: %s", err)
+		_ = event.react(":warning:")
+	}
+}
+
+// keepTyping shows the bot is on it while the command takes more than a moment, until the returned function is called.
+func (event messageEvent) keepTyping() func() {
+	done := make(chan struct{})
+	go func() {
+		delay := typingDelay
+		for {
+			select {
+			case <-done:
+				return
+			case <-event.ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+			if err := event.session.ChannelTyping(event.message.ChannelID); err != nil {
+				log.Printf("Unable to show typing: %v", err)
+				return
+			}
+			delay = typingInterval
 		}
+	}()
+	return func() { close(done) }
+}
+
+func (event messageEvent) attachments() error {
+	channel := store.channel(event.message.ChannelID)
+	if channel.dispatcher == nil {
+		return nil
+	}
+	user := store.user(event.message.Author.ID)
+	if !user.IsAdmin {
+		return errUnauthorized
 	}
+	for _, attachment := range event.message.Attachments {
+		err := channel.dispatcher.upload(event, attachment)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (event messageEvent) commands() error {
@@ -106,10 +200,48 @@ func (event messageEvent) commands() error {
 		return event.commandOpme()
 	case "aws":
 		return event.commandAws()
+	case "s3":
+		return event.commandS3()
 	case "setup":
 		return event.commandSetup()
 	case "play":
 		return event.commandPlay()
+	case "timeouts":
+		return event.commandTimeouts()
+	case "version":
+		return event.commandVersion()
+	case "modportal":
+		return event.commandModPortal()
+	case "mods":
+		return event.commandMods()
+	case "map":
+		return event.commandMap()
+	case "server":
+		return event.commandServer()
+	case "link":
+		return event.commandLink()
+	case "unlink":
+		return event.commandUnlink()
+	case "roles":
+		return event.commandRoles()
+	case "stats":
+		return event.commandStats()
+	case "cost":
+		return event.commandCost()
+	case "provider":
+		return event.commandProvider()
+	case "address":
+		return event.commandAddress()
+	case "distro":
+		return event.commandDistro()
+	case "spot":
+		return event.commandSpot()
+	case "schedule":
+		return event.commandSchedule()
+	case "status":
+		return event.commandStatus()
+	case "stop":
+		return event.commandStop()
 	default:
 		return nil
 	}
@@ -122,14 +254,22 @@ func (event messageEvent) commandOpme() error {
 			return event.react(":white_check_mark:")
 		} else {
 			author := event.message.Author
-			user.confirmation = randString() + randString() + randString()
-			log.Printf("Tell %s#%s >opme %s", author.Username, author.Discriminator, user.confirmation)
+			confirmation := randString() + randString() + randString()
+			storeLock.Lock()
+			user.confirmation = confirmation
+			storeLock.Unlock()
+			log.Printf("Tell %s#%s >opme %s", author.Username, author.Discriminator, confirmation)
 			return event.react(":thinking:")
 		}
 	} else {
 		password := event.command[1]
-		if password == user.confirmation {
+		storeLock.Lock()
+		confirmed := password == user.confirmation
+		if confirmed {
 			user.IsAdmin = true
+		}
+		storeLock.Unlock()
+		if confirmed {
 			store.store()
 			return event.react(":white_check_mark:")
 		} else {
@@ -147,8 +287,10 @@ func (event messageEvent) commandAws() error {
 		return event.reply("Expected: `>aws region-name bucket-name`")
 	}
 	guild := store.guild(event.message.GuildID)
+	storeLock.Lock()
 	guild.Region = event.command[1]
 	guild.Bucket = event.command[2]
+	storeLock.Unlock()
 	store.store()
 	return event.react(":white_check_mark:")
 }
@@ -164,8 +306,10 @@ func (event messageEvent) commandSetup() error {
 	}
 
 	if len(event.command) > 1 {
+		storeLock.Lock()
 		channel.Game = event.command[1]
 		channel.dispatcher = allDispatchers[channel.Game]
+		storeLock.Unlock()
 	}
 	if channel.dispatcher == nil {
 		return event.reply("Try `>setup factorio`")
@@ -176,11 +320,36 @@ func (event messageEvent) commandSetup() error {
 
 func (event messageEvent) commandPlay() error {
 	channel := store.channel(event.message.ChannelID)
-	if channel.dispatcher != nil {
-		return channel.dispatcher.play(event)
-	} else {
+	if channel.dispatcher == nil {
 		return event.react(":shrug:")
 	}
+	if until, quiet := channel.Shutdown.quietUntil(time.Now()); quiet {
+		return event.reply(fmt.Sprintf("Quiet hours until %s; no playing before then.", until.Format("15:04 MST")))
+	}
+	if !store.startPlaying(channel) {
+		return event.reply("The server is already starting.")
+	}
+	defer store.donePlaying(channel)
+	running, err := serverRunning(event.ctx, store.guild(event.message.GuildID), channel)
+	if err != nil {
+		return err
+	}
+	if running {
+		return event.reply("The server is already running; `>stop` it first to start another.")
+	}
+	return channel.dispatcher.play(event)
+}
+
+// serverRunning tells whether the channel's last server is still up, or about to be.
+func serverRunning(ctx context.Context, guild *GuildStore, channel *ChannelStore) (bool, error) {
+	if channel.Instance == "" {
+		return false, nil
+	}
+	status, err := providerNamed(channel.InstanceProvider).status(ctx, guild, channel.Instance)
+	if err != nil {
+		return false, err
+	}
+	return status == "pending" || status == "running", nil
 }
 
 func (event messageEvent) reply(message string) error {
@@ -192,8 +361,53 @@ func (event messageEvent) react(emoji string) error {
 	return event.session.MessageReactionAdd(event.message.ChannelID, event.message.ID, emoji)
 }
 
+// fetchAttachment downloads something attached to the message into a temporary file, which the caller removes.
+func (event messageEvent) fetchAttachment(attachment *discordgo.MessageAttachment) (*os.File, error) {
+	request, err := http.NewRequestWithContext(event.ctx, http.MethodGet, attachment.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer launchers.CloseDontCare(response.Body)
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("discord answered %s for %s", response.Status, attachment.Filename)
+	}
+	file, err := os.CreateTemp("", "narval-attachment-")
+	if err != nil {
+		return nil, err
+	}
+	if _, err = io.Copy(file, response.Body); err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		closeAndRemove(file)
+		return nil, err
+	}
+	return file, nil
+}
+
+func closeAndRemove(file *os.File) {
+	launchers.CloseDontCare(file)
+	_ = os.Remove(file.Name())
+}
+
+func (event messageEvent) getS3file(filename string) ([]byte, error) {
+	guild := store.guild(event.message.GuildID)
+	key := path.Join(event.message.ChannelID, filename)
+	return s3download(event.ctx, guild, key)
+}
+
+func (event messageEvent) deleteS3file(filename string) error {
+	guild := store.guild(event.message.GuildID)
+	key := path.Join(event.message.ChannelID, filename)
+	return s3delete(event.ctx, guild, key)
+}
+
 func (event messageEvent) putS3file(filename string, reader io.Reader) error {
 	guild := store.guild(event.message.GuildID)
 	key := path.Join(event.message.ChannelID, filename)
-	return s3upload(guild, key, reader)
+	return s3upload(event.ctx, guild, key, reader)
 }
@@ -0,0 +1,80 @@
+package dispatcher
+
+import (
+	"context"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	route53types "github.com/aws/aws-sdk-go-v2/service/route53/types"
+)
+
+const dnsTtl = 60
+
+// route53upsert points a name in the guild's hosted zone at the server, so players connect to the same address every
+// session. A kind of address the server doesn't have is removed, so clients don't try the last server's.
+func route53upsert(ctx context.Context, guild *GuildStore, name, ipv4, ipv6 string) error {
+	client, err := newRoute53client(ctx)
+	if err != nil {
+		return err
+	}
+	var changes []route53types.Change
+	for _, address := range []struct {
+		recordType route53types.RRType
+		value      string
+	}{{route53types.RRTypeA, ipv4}, {route53types.RRTypeAaaa, ipv6}} {
+		if address.value != "" {
+			changes = append(changes, route53types.Change{
+				Action: route53types.ChangeActionUpsert,
+				ResourceRecordSet: &route53types.ResourceRecordSet{
+					Name:            &name,
+					Type:            address.recordType,
+					TTL:             aws.Int64(dnsTtl),
+					ResourceRecords: []route53types.ResourceRecord{{Value: aws.String(address.value)}},
+				},
+			})
+			continue
+		}
+		existing, err := route53record(ctx, client, guild, name, address.recordType)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			// Route 53 only deletes a record given exactly as it is
+			changes = append(changes, route53types.Change{
+				Action:            route53types.ChangeActionDelete,
+				ResourceRecordSet: existing,
+			})
+		}
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	_, err = client.ChangeResourceRecordSets(ctx, &route53.ChangeResourceRecordSetsInput{
+		HostedZoneId: &guild.HostedZone,
+		ChangeBatch:  &route53types.ChangeBatch{Comment: aws.String("narval"), Changes: changes},
+	})
+	return err
+}
+
+// route53record finds a record of the hosted zone, or nil if there is none. Listing starts from the record, but goes
+// on with the next ones when it doesn't exist.
+func route53record(ctx context.Context, client route53api, guild *GuildStore, name string,
+	recordType route53types.RRType) (*route53types.ResourceRecordSet, error) {
+	output, err := client.ListResourceRecordSets(ctx, &route53.ListResourceRecordSetsInput{
+		HostedZoneId:    &guild.HostedZone,
+		StartRecordName: &name,
+		StartRecordType: recordType,
+		MaxItems:        aws.Int32(1),
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, set := range output.ResourceRecordSets {
+		if set.Type == recordType && strings.EqualFold(strings.TrimSuffix(aws.ToString(set.Name), "."),
+			strings.TrimSuffix(name, ".")) {
+			return &set, nil
+		}
+	}
+	return nil, nil
+}
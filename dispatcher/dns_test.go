@@ -0,0 +1,62 @@
+package dispatcher
+
+import (
+	"context"
+	"narval/fakes"
+	"testing"
+
+	route53types "github.com/aws/aws-sdk-go-v2/service/route53/types"
+)
+
+func useFakeRoute53(t *testing.T) *fakes.Route53 {
+	fake := fakes.NewRoute53()
+	previous := newRoute53client
+	newRoute53client = func(context.Context) (route53api, error) { return fake, nil }
+	t.Cleanup(func() { newRoute53client = previous })
+	return fake
+}
+
+func TestRoute53Upsert(t *testing.T) {
+	fake := useFakeRoute53(t)
+	guild := &GuildStore{HostedZone: "Z0123"}
+	err := route53upsert(context.Background(), guild, "factory.example.com", "198.51.100.7", "2001:db8::7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record := fake.Record("Z0123", "factory.example.com", route53types.RRTypeA); record != "198.51.100.7" {
+		t.Errorf("A record is %q", record)
+	}
+	if record := fake.Record("Z0123", "factory.example.com", route53types.RRTypeAaaa); record != "2001:db8::7" {
+		t.Errorf("AAAA record is %q", record)
+	}
+
+	err = route53upsert(context.Background(), guild, "factory.example.com", "198.51.100.8", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record := fake.Record("Z0123", "factory.example.com", route53types.RRTypeA); record != "198.51.100.8" {
+		t.Errorf("A record is %q after moving", record)
+	}
+	if record := fake.Record("Z0123", "factory.example.com", route53types.RRTypeAaaa); record != "" {
+		t.Errorf("AAAA record is still %q on a server without IPv6", record)
+	}
+
+	err = route53upsert(context.Background(), guild, "factory.example.com", "", "2001:db8::9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record := fake.Record("Z0123", "factory.example.com", route53types.RRTypeA); record != "" {
+		t.Errorf("A record is still %q on a server without IPv4", record)
+	}
+	if record := fake.Record("Z0123", "factory.example.com", route53types.RRTypeAaaa); record != "2001:db8::9" {
+		t.Errorf("AAAA record is %q", record)
+	}
+}
+
+func TestRoute53UpsertWithoutAddresses(t *testing.T) {
+	useFakeRoute53(t)
+	// The fake refuses empty change batches, like Route 53 does
+	if err := route53upsert(context.Background(), &GuildStore{HostedZone: "Z0123"}, "factory.example.com", "", ""); err != nil {
+		t.Error(err)
+	}
+}
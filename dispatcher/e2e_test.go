@@ -0,0 +1,221 @@
+package dispatcher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"narval/fakes"
+	"narval/launchers"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	testBotID     = "100"
+	testAdminID   = "101"
+	testGuildID   = "200"
+	testChannelID = "300"
+	testBucket    = "narval-test"
+	testVersion   = "1.1.110"
+)
+
+// testFactorio plays the part of the game: it makes an empty save when asked to create a map, then goes through a
+// short session and does as it's told on stdin.
+const testFactorio = `#!/bin/sh
+if [ "$1" = "--create" ]; then
+	{ printf 'PK\005\006'; head -c 18 /dev/zero; } > "$2"
+	exit 0
+fi
+echo "   0.100 Info ServerMultiplayerManager.cpp:1: changing state from(CreatingGame) to(InGame)"
+echo "2021-06-01 12:00:01 [JOIN] alice joined the game"
+echo "2021-06-01 12:00:02 [CHAT] alice: hello"
+echo "2021-06-01 12:00:03 [LEAVE] alice left the game"
+while read -r line; do
+	case "$line" in
+	/server-save) echo "   5.000 Info AppManagerStates.cpp:1: Saving finished" ;;
+	/quit) exit 0 ;;
+	esac
+done
+`
+
+// testLauncher stands in for a launcher built for another platform.
+const testLauncher = "#!/bin/sh\n"
+
+// webhookRecorder collects what the launcher says in Discord.
+type webhookRecorder struct {
+	lock     sync.Mutex
+	messages []string
+}
+
+func (recorder *webhookRecorder) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	var body struct{ Content string }
+	if err := json.NewDecoder(request.Body).Decode(&body); err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	recorder.lock.Lock()
+	recorder.messages = append(recorder.messages, body.Content)
+	recorder.lock.Unlock()
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+func (recorder *webhookRecorder) said(part string) bool {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+	for _, message := range recorder.messages {
+		if strings.Contains(message, part) {
+			return true
+		}
+	}
+	return false
+}
+
+func setupFakes(t *testing.T) (*fakes.S3, *fakes.EC2, *fakes.Discord) {
+	fakeS3, fakeEc2, fakeDiscord := fakes.NewS3(), fakes.NewEC2(), fakes.NewDiscord()
+	previousS3, previousEc2 := newS3client, newEc2client
+	newS3client = func(context.Context, *GuildStore) (s3api, error) { return fakeS3, nil }
+	newEc2client = func(context.Context, *GuildStore) (ec2api, error) { return fakeEc2, nil }
+	launchers.UseS3Client(fakeS3)
+	t.Cleanup(func() {
+		newS3client, newEc2client = previousS3, previousEc2
+	})
+
+	initializeStore()
+	if err := loadPrices(); err != nil {
+		t.Fatal(err)
+	}
+	store.user(testAdminID).IsAdmin = true
+	return fakeS3, fakeEc2, fakeDiscord
+}
+
+func sendMessage(t *testing.T, session *fakes.Discord, content string) {
+	reactions := len(session.Reactions)
+	handleMessage(session, testBotID, &discordgo.MessageCreate{Message: &discordgo.Message{
+		ID:        fmt.Sprint(len(session.Sent) + 1000),
+		ChannelID: testChannelID,
+		GuildID:   testGuildID,
+		Author:    &discordgo.User{ID: testAdminID},
+		Content:   content,
+	}})
+	for _, reaction := range session.Reactions[reactions:] {
+		if reaction == ":warning:" || reaction == ":unamused:" {
+			t.Fatalf("%s got %s", content, reaction)
+		}
+	}
+}
+
+// installTestFactorio puts a stand-in for the game where the launcher expects it, so it doesn't download anything.
+func installTestFactorio(t *testing.T) {
+	binary := filepath.FromSlash("game/factorio/bin/x64/factorio")
+	info := filepath.FromSlash("game/factorio/data/base/info.json")
+	for _, path := range []string{binary, info} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(binary, []byte(testFactorio), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(info, []byte(`{"name": "base", "version": "`+testVersion+`"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func chdir(t *testing.T, folder string) {
+	previous, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(folder); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Chdir(previous) })
+}
+
+// TestPlay goes from setting up a channel to a whole session on the launcher and back to its stats, all offline.
+func TestPlay(t *testing.T) {
+	fakeS3, fakeEc2, session := setupFakes(t)
+	// Whatever this test runs on, the instance wants an amd64 build
+	artifacts := t.TempDir()
+	if err := os.WriteFile(filepath.Join(artifacts, "narval-linux-amd64"), []byte(testLauncher), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("LAUNCHER_ARTIFACTS", artifacts)
+
+	sendMessage(t, session, ">aws us-east-1 "+testBucket)
+	sendMessage(t, session, ">setup factorio")
+	if _, found := fakeS3.Object(testBucket, testChannelID+"/init.json"); !found {
+		t.Fatalf("no init.json in %v", fakeS3.Keys(testBucket))
+	}
+	sendMessage(t, session, ">version "+testVersion)
+	sendMessage(t, session, ">timeouts startup 1s")
+	sendMessage(t, session, ">timeouts idle 1s")
+	sendMessage(t, session, ">play")
+
+	if len(fakeEc2.UserData) != 1 {
+		t.Fatalf("started %d instances", len(fakeEc2.UserData))
+	}
+	variables := userDataVariables(fakeEc2.UserData[0])
+	build, err := launcherBuildFor("linux", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(variables["LAUNCHER_URL"], "/"+build.key()+"?") || variables["LAUNCHER_SHA256"] != build.sha256 {
+		t.Errorf("the instance would run %s, expected %s", variables["LAUNCHER_URL"], build.key())
+	}
+	if uploaded, _ := fakeS3.Object(testBucket, build.key()); string(uploaded) != testLauncher {
+		t.Fatal("the launcher for the instance wasn't uploaded")
+	}
+	for name, expected := range map[string]string{
+		"LAUNCH":           "factorio",
+		"BUCKET":           testBucket,
+		"PREFIX":           testChannelID + "/",
+		"FACTORIO_VERSION": testVersion,
+		"SHUTDOWN_GRACE":   "1s",
+	} {
+		if variables[name] != expected {
+			t.Errorf("%s is %q, expected %q", name, variables[name], expected)
+		}
+	}
+	if channel := store.channel(testChannelID); channel.Instance == "" || len(store.guild(testGuildID).Sessions) != 1 {
+		t.Errorf("the instance isn't tracked: %+v", channel)
+	}
+
+	// Now be the instance
+	webhook := &webhookRecorder{}
+	server := httptest.NewServer(webhook)
+	defer server.Close()
+	variables["WEBHOOK_URL"] = server.URL
+	for name, value := range variables {
+		t.Setenv(name, value)
+	}
+	chdir(t, t.TempDir())
+	installTestFactorio(t)
+	if err := launchers.Launch(variables["LAUNCH"]); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{"Server is ready!", "`[ 1]` :star2: alice", "`<alice>` hello", "Server shut down."} {
+		if !webhook.said(expected) {
+			t.Errorf("never said %q in %q", expected, webhook.messages)
+		}
+	}
+	for _, key := range []string{"state/save.zip", "state/server-adminlist.json", "stats/" + variables["SESSION"] + ".json"} {
+		if _, found := fakeS3.Object(testBucket, testChannelID+"/"+key); !found {
+			t.Errorf("no %s in %v", key, fakeS3.Keys(testBucket))
+		}
+	}
+
+	sendMessage(t, session, ">stats")
+	said := session.Said()
+	if last := said[len(said)-1]; !strings.HasPrefix(last, "1 sessions") || !strings.Contains(last, "`alice`") {
+		t.Errorf("stats said %q", last)
+	}
+}
@@ -3,3 +3,7 @@ package dispatcher
 import "errors"
 
 var errUnauthorized = errors.New("unauthorized")
+var errNotPositive = errors.New("must be positive")
+var errUnknownPrice = errors.New("no price known for the instance")
+var errOverBudget = errors.New("over the monthly budget")
+var errNoLauncherBuild = errors.New("no launcher built for the instance")
@@ -3,6 +3,12 @@ package dispatcher
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
+	"github.com/bwmarrin/discordgo"
+	"io"
+	"log"
+	"narval/launchers"
+	"path"
 	"strings"
 )
 
@@ -28,27 +34,296 @@ func (factorioDispatcher) setup(event messageEvent) error {
 	// respond :D
 	message := []string{
 		"All right, let's build an awesome factory!",
-		// "If you want an initial save game, send your save zip file.",
-		// "If you want mods, zip your `%appdata%\\Factorio\\mods` folder and send it over.",
+		"If you want an initial save game, send your save zip file.",
+		"If you want mods, zip your `%appdata%\\Factorio\\mods` folder and send it over.",
 		// "Some server json files are accepted too, including world settings with world seed.",
-		"When you are ready, say `>start`",
+		"When you are ready, say `>play`",
 	}
 	return event.reply(strings.Join(message, "\n"))
 }
 
-func (factorioDispatcher) play(event messageEvent) error {
-	guild := store.guild(event.message.GuildID)
+func (factorioDispatcher) upload(event messageEvent, attachment *discordgo.MessageAttachment) error {
+	if !launchers.IsArchiveName(attachment.Filename) {
+		return nil // Not for us
+	}
+	file, err := event.fetchAttachment(attachment)
+	if err != nil {
+		return err
+	}
+	defer closeAndRemove(file)
+
+	header, headerErr := launchers.ReadSaveHeader(file, int64(attachment.Size))
+	if header.Version != (launchers.Version{}) {
+		return event.uploadSave(file, header, headerErr)
+	}
+	// The launcher unpacks these into the mods folder the next time it starts
+	err = event.putS3file(path.Join("uploads/mods", path.Base(attachment.Filename)), file)
+	if err != nil {
+		return err
+	}
+	return event.react(":package:")
+}
+
+// uploadSave makes the save the channel's, and tells what doesn't match about it.
+func (event messageEvent) uploadSave(file io.Reader, header launchers.SaveHeader, headerErr error) error {
+	err := event.putS3file("state/save.zip", file)
+	if err != nil {
+		return err
+	}
 	channel := store.channel(event.message.ChannelID)
-	channel.session = randString()
-	err := s3uploadSelf(guild)
+	message := []string{fmt.Sprintf("Got a save from Factorio %v.", header.Version)}
+
+	requested := channel.GameVersion
+	if requested == "" {
+		requested = "stable"
+	}
+	if resolved, err := launchers.ResolveFactorioVersion(requested); err == nil && resolved.Less(header.Version) {
+		message = append(message, fmt.Sprintf(
+			"This channel runs Factorio %v, which can't load it; try `>version %v`.", resolved, header.Version))
+	}
+
+	if headerErr != nil {
+		log.Printf("Unable to read the mods of a save: %v", headerErr)
+		message = append(message, "I couldn't read which mods it uses, so check `>mods` yourself.")
+		return event.reply(strings.Join(message, "\n"))
+	}
+	list, pins, err := event.getModList()
 	if err != nil {
 		return err
 	}
+	mismatches := saveModMismatches(header.Mods, list, pins)
+	if len(mismatches) == 0 {
+		message = append(message, "Its mods match this channel's :white_check_mark:")
+		storeLock.Lock()
+		channel.saveMods = nil
+		storeLock.Unlock()
+	} else {
+		message = append(message, "Its mods don't match this channel's:")
+		message = append(message, mismatches...)
+		message = append(message, "Say `>mods align` to use the same mods as the save.")
+		storeLock.Lock()
+		channel.saveMods = header.Mods
+		storeLock.Unlock()
+	}
+	return event.reply(strings.Join(message, "\n"))
+}
+
+func saveModMismatches(saveMods []launchers.SaveMod, list *launchers.ModList, pins map[string]string) []string {
+	var result []string
+	inSave := map[string]bool{}
+	for _, mod := range saveMods {
+		inSave[mod.Name] = true
+		switch {
+		case !list.IsEnabled(mod.Name) && !launchers.IsBuiltInMod(mod.Name):
+			result = append(result, fmt.Sprintf("- `%s` %v isn't enabled", mod.Name, mod.Version))
+		case pins[mod.Name] != "" && pins[mod.Name] != mod.Version.String():
+			result = append(result, fmt.Sprintf("- `%s` is %v in the save but pinned to %s", mod.Name, mod.Version,
+				pins[mod.Name]))
+		}
+	}
+	for _, name := range list.EnabledNames() {
+		if !inSave[name] && !launchers.IsBuiltInMod(name) {
+			result = append(result, fmt.Sprintf("- `%s` is enabled but the save doesn't use it", name))
+		}
+	}
+	return result
+}
+
+func (factorioDispatcher) play(event messageEvent) error {
+	guild := store.guild(event.message.GuildID)
+	channel := store.channel(event.message.ChannelID)
+	storeLock.Lock()
+	channel.session = randString()
+	storeLock.Unlock()
 	variables := map[string]string{
 		"LAUNCH":  "factorio",
 		"BUCKET":  guild.Bucket,
 		"PREFIX":  channel.Prefix,
 		"SESSION": channel.session,
 	}
-	return ec2makeServer(guild, variables)
+	guild.S3Endpoint.AddVariables(variables)
+	if channel.GameVersion != "" {
+		variables["FACTORIO_VERSION"] = channel.GameVersion
+	}
+	var err error
+	variables["WEBHOOK_URL"], err = event.webhookUrl(channel)
+	if err != nil {
+		return err
+	}
+	if links := playerLinksVariable(); links != "" {
+		variables["PLAYER_LINKS"] = links
+	}
+	// Roles change without us noticing, so catch up with them
+	err = event.putPlayerLists(channel)
+	if err != nil {
+		return err
+	}
+	if guild.FactorioToken != "" {
+		variables["FACTORIO_USERNAME"] = guild.FactorioUsername
+		variables["FACTORIO_TOKEN"] = guild.FactorioToken
+	}
+	channel.Shutdown.addVariables(variables)
+	return event.launch(guild, variables)
+}
+
+func (event messageEvent) commandVersion() error {
+	channel := store.channel(event.message.ChannelID)
+	if len(event.command) == 1 {
+		requested := channel.GameVersion
+		if requested == "" {
+			requested = "stable"
+		}
+		resolved, err := launchers.ResolveFactorioVersion(requested)
+		if err != nil {
+			return event.reply(fmt.Sprintf("Following `%s`, which I can't resolve right now (%v).", requested, err))
+		}
+		return event.reply(fmt.Sprintf("Following `%s`, currently Factorio %v.", requested, resolved))
+	}
+
+	user := store.user(event.message.Author.ID)
+	if !user.IsAdmin {
+		return errUnauthorized
+	}
+	requested := event.command[1]
+	if requested == "upgrade" {
+		// Pin whatever stable is right now, so the next stable release doesn't surprise anyone
+		resolved, err := launchers.ResolveFactorioVersion("stable")
+		if err != nil {
+			return err
+		}
+		requested = resolved.String()
+	}
+	if len(event.command) != 2 || !launchers.IsFactorioVersionRequest(requested) {
+		return event.reply("Expected: `>version stable|experimental|upgrade|1.1.110`")
+	}
+	if requested == "stable" || requested == "latest" {
+		requested = ""
+	}
+	storeLock.Lock()
+	channel.GameVersion = requested
+	storeLock.Unlock()
+	store.store()
+	return event.react(":white_check_mark:")
+}
+
+// commandModPortal keeps the factorio.com credentials the launcher needs to download mods.
+func (event messageEvent) commandModPortal() error {
+	user := store.user(event.message.Author.ID)
+	if !user.IsAdmin {
+		return errUnauthorized
+	}
+	if len(event.command) != 3 {
+		return event.reply("Expected: `>modportal username token` (the token is in your `player-data.json`)")
+	}
+	guild := store.guild(event.message.GuildID)
+	storeLock.Lock()
+	guild.FactorioUsername = event.command[1]
+	guild.FactorioToken = event.command[2]
+	storeLock.Unlock()
+	store.store()
+	// The token is as good as a password, so don't leave it lying around
+	err := event.session.ChannelMessageDelete(event.message.ChannelID, event.message.ID)
+	if err != nil {
+		return err
+	}
+	_, err = event.session.ChannelMessageSend(event.message.ChannelID, "Got the mod portal credentials :white_check_mark:")
+	return err
+}
+
+func (event messageEvent) commandMods() error {
+	if len(event.command) > 1 && event.command[1] == "align" {
+		return event.commandModsAlign()
+	}
+	list, pins, err := event.getModList()
+	if err != nil {
+		return err
+	}
+
+	var lines []string
+	for _, name := range list.EnabledNames() {
+		if name == "base" {
+			continue
+		}
+		if pin, pinned := pins[name]; pinned {
+			lines = append(lines, fmt.Sprintf("`%s` %s", name, pin))
+		} else {
+			lines = append(lines, fmt.Sprintf("`%s`", name))
+		}
+	}
+	if len(lines) == 0 {
+		return event.reply("No mods enabled; it's vanilla.")
+	}
+	return event.reply(fmt.Sprintf("%d mods enabled: %s", len(lines), strings.Join(lines, ", ")))
+}
+
+// commandModsAlign enables exactly the mods of the last uploaded save, pinned to the versions it used.
+func (event messageEvent) commandModsAlign() error {
+	user := store.user(event.message.Author.ID)
+	if !user.IsAdmin {
+		return errUnauthorized
+	}
+	channel := store.channel(event.message.ChannelID)
+	if channel.saveMods == nil {
+		return event.reply("Send me a save first, then I can match its mods.")
+	}
+	list, pins, err := event.getModList()
+	if err != nil {
+		return err
+	}
+	for i := range list.Mods {
+		list.Mods[i].Enabled = false
+	}
+	pins = map[string]string{}
+	for _, mod := range channel.saveMods {
+		list.Enable(mod.Name)
+		if !launchers.IsBuiltInMod(mod.Name) {
+			pins[mod.Name] = mod.Version.String()
+		}
+	}
+
+	listJson, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	pinsJson, err := json.MarshalIndent(pins, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = event.putS3file("state/mods/mod-list.json", bytes.NewReader(listJson))
+	if err != nil {
+		return err
+	}
+	err = event.putS3file("state/mods/mod-versions.json", bytes.NewReader(pinsJson))
+	if err != nil {
+		return err
+	}
+	storeLock.Lock()
+	channel.saveMods = nil
+	storeLock.Unlock()
+	return event.react(":white_check_mark:")
+}
+
+// getModList reads the channel's mod-list.json and version pins from its state; no mod list means vanilla.
+func (event messageEvent) getModList() (*launchers.ModList, map[string]string, error) {
+	list := &launchers.ModList{}
+	pins := map[string]string{}
+	buffer, err := event.getS3file("state/mods/mod-list.json")
+	if err != nil {
+		return nil, nil, err
+	}
+	if buffer != nil {
+		if list, err = launchers.ReadModList(bytes.NewReader(buffer)); err != nil {
+			return nil, nil, err
+		}
+	}
+	buffer, err = event.getS3file("state/mods/mod-versions.json")
+	if err != nil {
+		return nil, nil, err
+	}
+	if buffer != nil {
+		if err = json.Unmarshal(buffer, &pins); err != nil {
+			return nil, nil, err
+		}
+	}
+	return list, pins, nil
 }
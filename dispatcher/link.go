@@ -0,0 +1,229 @@
+package dispatcher
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/bwmarrin/discordgo"
+	"narval/launchers"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// linkRegexpChat finds a link code in game chat relayed by the launcher, which looks like "`<name>` link code".
+var linkRegexpChat = regexp.MustCompile("`<(.+?)>` link (\\S+)$")
+var linkRegexpRole = regexp.MustCompile(`^<@&(\d+)>$`)
+
+// commandLink starts linking the author to a Factorio player, which they finish by saying the code in game.
+func (event messageEvent) commandLink() error {
+	user := store.user(event.message.Author.ID)
+	if len(event.command) == 1 {
+		if user.FactorioName == "" {
+			return event.reply("Not linked yet; try `>link your-factorio-name`")
+		}
+		return event.reply(fmt.Sprintf("Linked to `%s`.", user.FactorioName))
+	}
+	name, code := strings.Join(event.command[1:], " "), randString()
+	storeLock.Lock()
+	user.linkName, user.linkCode = name, code
+	storeLock.Unlock()
+	message := fmt.Sprintf("Join the game as `%s` and say `link %s` in chat.", name, code)
+	return event.reply(message)
+}
+
+func (event messageEvent) commandUnlink() error {
+	user := store.user(event.message.Author.ID)
+	storeLock.Lock()
+	user.FactorioName = ""
+	storeLock.Unlock()
+	store.store()
+	return event.react(":white_check_mark:")
+}
+
+// webhookMessage looks at what the launcher relays from the game, for link codes, and at what it says about the
+// instance going away.
+func (event messageEvent) webhookMessage() error {
+	channel := store.channel(event.message.ChannelID)
+	if channel.WebhookID == "" || event.message.WebhookID != channel.WebhookID {
+		return nil
+	}
+	if event.message.Content == launchers.SpotReclaimedMessage {
+		return event.spotReclaimed(channel)
+	}
+	matches := linkRegexpChat.FindStringSubmatch(event.message.Content)
+	if matches == nil {
+		return nil
+	}
+	storeLock.Lock()
+	var linkedID Snowflake
+	var linked *UserStore
+	for id, user := range store.Users {
+		if user.linkCode != "" && user.linkName == matches[1] && user.linkCode == matches[2] {
+			user.FactorioName = user.linkName
+			user.linkName, user.linkCode = "", ""
+			linkedID, linked = id, user
+			break
+		}
+	}
+	// A name belongs to whoever proved it last
+	for id, user := range store.Users {
+		if linked != nil && id != linkedID && user.FactorioName == linked.FactorioName {
+			user.FactorioName = ""
+		}
+	}
+	storeLock.Unlock()
+	if linked == nil {
+		return nil
+	}
+	store.store()
+	_, err := event.session.ChannelMessageSendComplex(event.message.ChannelID, &discordgo.MessageSend{
+		Content:         fmt.Sprintf("Linked <@%s> to `%s` :link:", linkedID, linked.FactorioName),
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+	})
+	return err
+}
+
+// commandRoles picks the Discord roles whose linked members become server admins, or are the only ones let in.
+func (event messageEvent) commandRoles() error {
+	channel := store.channel(event.message.ChannelID)
+	show := func(role string) string {
+		if role == "" {
+			return "none"
+		}
+		return "<@&" + role + ">"
+	}
+	if len(event.command) == 1 {
+		_, err := event.session.ChannelMessageSendComplex(event.message.ChannelID, &discordgo.MessageSend{
+			Content:         fmt.Sprintf("admin: %s\nplayer: %s", show(channel.AdminRole), show(channel.PlayerRole)),
+			AllowedMentions: &discordgo.MessageAllowedMentions{},
+			Reference:       event.message.Reference(),
+		})
+		return err
+	}
+	user := store.user(event.message.Author.ID)
+	if !user.IsAdmin {
+		return errUnauthorized
+	}
+	if len(event.command) != 3 || (event.command[1] != "admin" && event.command[1] != "player") {
+		return event.reply("Expected: `>roles admin|player @role|none`")
+	}
+	role := ""
+	if event.command[2] != "none" {
+		matches := linkRegexpRole.FindStringSubmatch(event.command[2])
+		if matches == nil {
+			return event.reply("Expected: `>roles admin|player @role|none`")
+		}
+		role = matches[1]
+	}
+	storeLock.Lock()
+	switch event.command[1] {
+	case "admin":
+		channel.AdminRole = role
+	case "player":
+		channel.PlayerRole = role
+	}
+	storeLock.Unlock()
+	store.store()
+	err := event.putPlayerLists(channel)
+	if err != nil {
+		return err
+	}
+	return event.react(":white_check_mark:")
+}
+
+// linkedPlayers maps Factorio names to the Discord users linked to them.
+func linkedPlayers() map[string]Snowflake {
+	storeLock.Lock()
+	defer storeLock.Unlock()
+	result := map[string]Snowflake{}
+	for id, user := range store.Users {
+		if user.FactorioName != "" {
+			result[user.FactorioName] = id
+		}
+	}
+	return result
+}
+
+// playerLinksVariable tells the launcher who is who, as "name=id" pairs separated by newlines.
+func playerLinksVariable() string {
+	var pairs []string
+	for name, id := range linkedPlayers() {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", name, id))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "\n")
+}
+
+// playersWithRole lists the Factorio names of linked guild members that have the role.
+func (event messageEvent) playersWithRole(role string) ([]string, error) {
+	var result []string
+	for name, id := range linkedPlayers() {
+		member, err := event.session.GuildMember(event.message.GuildID, id.String())
+		if err != nil {
+			continue // Not in this guild
+		}
+		if containsString(member.Roles, role) {
+			result = append(result, name)
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// putPlayerLists writes the server's admin list, from the settings and the admin role, and its whitelist, from the
+// player role; without a player role, anyone can join.
+func (event messageEvent) putPlayerLists(channel *ChannelStore) error {
+	admins := append([]string{}, channel.ServerSettings.Admins...)
+	if channel.AdminRole != "" {
+		roleAdmins, err := event.playersWithRole(channel.AdminRole)
+		if err != nil {
+			return err
+		}
+		for _, name := range roleAdmins {
+			if !containsString(admins, name) {
+				admins = append(admins, name)
+			}
+		}
+	}
+	buffer, err := json.MarshalIndent(admins, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = event.putS3file("state/server-adminlist.json", bytes.NewReader(buffer))
+	if err != nil {
+		return err
+	}
+
+	if channel.PlayerRole == "" {
+		return event.deleteS3file("state/server-whitelist.json")
+	}
+	players, err := event.playersWithRole(channel.PlayerRole)
+	if err != nil {
+		return err
+	}
+	players = append(players, admins...)
+	if players == nil {
+		players = []string{}
+	}
+	buffer, err = json.MarshalIndent(players, "", "  ")
+	if err != nil {
+		return err
+	}
+	return event.putS3file("state/server-whitelist.json", bytes.NewReader(buffer))
+}
+
+// webhookUrl is where the launcher says things in the channel, made the first time it's needed.
+func (event messageEvent) webhookUrl(channel *ChannelStore) (string, error) {
+	if channel.WebhookID == "" {
+		webhook, err := event.session.WebhookCreate(event.message.ChannelID, "Narval", "")
+		if err != nil {
+			return "", err
+		}
+		storeLock.Lock()
+		channel.WebhookID, channel.WebhookToken = webhook.ID, webhook.Token
+		storeLock.Unlock()
+		store.store()
+	}
+	return discordgo.EndpointWebhookToken(channel.WebhookID, channel.WebhookToken), nil
+}
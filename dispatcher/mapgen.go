@@ -0,0 +1,156 @@
+package dispatcher
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// MapSettings is how the channel wants its map generated, the next time it starts without a save.
+type MapSettings struct {
+	Preset string
+	Seed   string
+	Width  int
+	Height int
+}
+
+var mapResources = []string{"coal", "stone", "copper-ore", "iron-ore", "uranium-ore", "crude-oil"}
+var mapPresets = []string{"default", "rich-resources", "death-world", "rail-world"}
+var mapRegexpSize = regexp.MustCompile(`^(\d+)x(\d+)$`)
+
+func autoplace(frequency, size, richness float64) jsObj {
+	return jsObj{"frequency": frequency, "size": size, "richness": richness}
+}
+
+// files renders map-gen-settings.json and map-settings.json. Anything left out keeps the game's default.
+func (settings MapSettings) files() (jsObj, jsObj) {
+	controls := jsObj{}
+	mapGen := jsObj{"width": settings.Width, "height": settings.Height, "autoplace_controls": controls}
+	if settings.Seed != "" {
+		seed, _ := strconv.ParseUint(settings.Seed, 10, 32)
+		mapGen["seed"] = seed
+	}
+	mapSettings := jsObj{}
+
+	switch settings.Preset {
+	case "rich-resources":
+		for _, resource := range mapResources {
+			controls[resource] = autoplace(1, 1, 2)
+		}
+	case "death-world":
+		controls["enemy-base"] = autoplace(2, 2, 1)
+		mapGen["starting_area"] = 0.75
+		mapSettings["enemy_evolution"] = jsObj{
+			"time_factor":      0.00002,
+			"destroy_factor":   0.002,
+			"pollution_factor": 0.0000012,
+		}
+		mapSettings["enemy_expansion"] = jsObj{
+			"min_expansion_cooldown": 4 * 60 * 60, // ticks
+			"max_expansion_cooldown": 20 * 60 * 60,
+		}
+	case "rail-world":
+		for _, resource := range mapResources {
+			controls[resource] = autoplace(0.33, 3, 1)
+		}
+		mapSettings["enemy_expansion"] = jsObj{"enabled": false}
+	}
+	return mapGen, mapSettings
+}
+
+func (settings MapSettings) String() string {
+	preset := settings.Preset
+	if preset == "" {
+		preset = "default"
+	}
+	seed := settings.Seed
+	if seed == "" {
+		seed = "random"
+	}
+	size := "infinite"
+	if settings.Width > 0 || settings.Height > 0 {
+		size = fmt.Sprintf("%dx%d", settings.Width, settings.Height)
+	}
+	return strings.Join([]string{"preset: " + preset, "seed: " + seed, "size: " + size}, "\n")
+}
+
+// set changes one setting, telling what was wrong with the value if it can't.
+func (settings *MapSettings) set(name, value string) string {
+	switch name {
+	case "preset":
+		if !containsString(mapPresets, value) {
+			return "Presets are " + strings.Join(mapPresets, ", ")
+		}
+		if value == "default" {
+			value = ""
+		}
+		settings.Preset = value
+	case "seed":
+		if value == "random" {
+			value = ""
+		} else if _, err := strconv.ParseUint(value, 10, 32); err != nil {
+			return "The seed is a number up to 4294967295, or `random`"
+		}
+		settings.Seed = value
+	case "size":
+		if value == "infinite" {
+			settings.Width, settings.Height = 0, 0
+			return ""
+		}
+		matches := mapRegexpSize.FindStringSubmatch(value)
+		if matches == nil {
+			return "The size is like `2000x2000`, in tiles, or `infinite`"
+		}
+		settings.Width, _ = strconv.Atoi(matches[1])
+		settings.Height, _ = strconv.Atoi(matches[2])
+	default:
+		return "Expected: `>map preset|seed|size value`"
+	}
+	return ""
+}
+
+func (event messageEvent) commandMap() error {
+	channel := store.channel(event.message.ChannelID)
+	if len(event.command) == 1 {
+		return event.reply("```\n" + channel.Map.String() + "\n```\nThese only matter when there is no save yet.")
+	}
+	user := store.user(event.message.Author.ID)
+	if !user.IsAdmin {
+		return errUnauthorized
+	}
+	if len(event.command) != 3 {
+		return event.reply("Expected: `>map preset|seed|size value`")
+	}
+	updated := channel.Map
+	if problem := updated.set(event.command[1], event.command[2]); problem != "" {
+		return event.reply(problem)
+	}
+
+	mapGen, mapSettings := updated.files()
+	for filename, contents := range map[string]jsObj{"map-gen-settings.json": mapGen, "map-settings.json": mapSettings} {
+		buffer, err := json.MarshalIndent(contents, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err = event.putS3file("state/"+filename, bytes.NewReader(buffer)); err != nil {
+			return err
+		}
+	}
+	storeLock.Lock()
+	channel.Map = updated
+	storeLock.Unlock()
+	store.store()
+	return event.react(":white_check_mark:")
+}
+
+func containsString(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
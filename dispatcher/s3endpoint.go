@@ -0,0 +1,59 @@
+package dispatcher
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const s3endpointUsage = "Expected: `>s3 endpoint https://host:port|none`, `>s3 pathstyle on|off` or `>s3 keys id secret|none`"
+
+// commandS3 points the guild's storage at something other than AWS, like MinIO, Ceph or Cloudflare R2.
+func (event messageEvent) commandS3() error {
+	guild := store.guild(event.message.GuildID)
+	if len(event.command) == 1 {
+		endpoint := guild.S3Endpoint
+		lines := []string{"endpoint: AWS", fmt.Sprintf("pathstyle: %v", endpoint.PathStyle), "keys: default"}
+		if endpoint.URL != "" {
+			lines[0] = "endpoint: " + endpoint.URL
+		}
+		if endpoint.AccessKey != "" {
+			lines[2] = "keys: " + endpoint.AccessKey
+		}
+		return event.reply("```\n" + strings.Join(lines, "\n") + "\n```")
+	}
+	user := store.user(event.message.Author.ID)
+	if !user.IsAdmin {
+		return errUnauthorized
+	}
+
+	endpoint := &guild.S3Endpoint
+	switch {
+	case len(event.command) == 3 && event.command[1] == "endpoint" && event.command[2] == "none":
+		endpoint.URL = ""
+	case len(event.command) == 3 && event.command[1] == "endpoint":
+		parsed, err := url.Parse(event.command[2])
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return event.reply(s3endpointUsage)
+		}
+		endpoint.URL = strings.TrimSuffix(event.command[2], "/")
+	case len(event.command) == 3 && event.command[1] == "pathstyle" && (event.command[2] == "on" || event.command[2] == "off"):
+		endpoint.PathStyle = event.command[2] == "on"
+	case len(event.command) == 3 && event.command[1] == "keys" && event.command[2] == "none":
+		endpoint.AccessKey, endpoint.SecretKey = "", ""
+	case len(event.command) == 4 && event.command[1] == "keys":
+		endpoint.AccessKey, endpoint.SecretKey = event.command[2], event.command[3]
+		store.store()
+		// The secret is as good as a password, so don't leave it lying around
+		err := event.session.ChannelMessageDelete(event.message.ChannelID, event.message.ID)
+		if err != nil {
+			return err
+		}
+		_, err = event.session.ChannelMessageSend(event.message.ChannelID, "Got the S3 keys :white_check_mark:")
+		return err
+	default:
+		return event.reply(s3endpointUsage)
+	}
+	store.store()
+	return event.react(":white_check_mark:")
+}
@@ -0,0 +1,38 @@
+package dispatcher
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPlayWithS3Endpoint(t *testing.T) {
+	_, fakeEc2, session := setupFakes(t)
+
+	sendMessage(t, session, ">aws us-east-1 "+testBucket)
+	sendMessage(t, session, ">s3 endpoint http://localhost:9000/")
+	sendMessage(t, session, ">s3 pathstyle on")
+	sendMessage(t, session, ">s3 keys minio secret'key")
+	if len(session.Deleted) != 1 {
+		t.Error("the message with the keys is still there")
+	}
+	sendMessage(t, session, ">setup factorio")
+	sendMessage(t, session, ">play")
+
+	if len(fakeEc2.UserData) != 1 {
+		t.Fatalf("started %d instances", len(fakeEc2.UserData))
+	}
+	variables := userDataVariables(fakeEc2.UserData[0])
+	for name, expected := range map[string]string{
+		"S3_ENDPOINT":          "http://localhost:9000",
+		"S3_PATH_STYLE":        "true",
+		"S3_ACCESS_KEY_ID":     "minio",
+		"S3_SECRET_ACCESS_KEY": "secret'key",
+	} {
+		if variables[name] != expected {
+			t.Errorf("%s is %q, expected %q", name, variables[name], expected)
+		}
+	}
+	if strings.Contains(fakeEc2.UserData[0], "aws s3") {
+		t.Errorf("the instance needs the AWS CLI:\n%s", fakeEc2.UserData[0])
+	}
+}
@@ -0,0 +1,263 @@
+package dispatcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Schedule starts the channel's server at the times a cron expression matches, in its time zone.
+type Schedule struct {
+	Cron     string
+	TimeZone string
+	Guild    Snowflake
+}
+
+// cronExpression is a parsed "minute hour day-of-month month day-of-week", each field a bit set of what matches.
+type cronExpression struct {
+	minute, hour, dayOfMonth, month, dayOfWeek uint64
+	anyDayOfMonth, anyDayOfWeek                bool
+}
+
+const scheduleUsage = "Expected: `>schedule add minute hour day month weekday [time-zone]`, like `>schedule add 30 19 * * fri Europe/Paris`, or `>schedule remove number`"
+
+var errInvalidCron = errors.New("expected five fields: minute hour day month weekday")
+
+var cronMonths = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+var cronWeekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+func parseCron(value string) (cronExpression, error) {
+	fields := strings.Fields(value)
+	if len(fields) != 5 {
+		return cronExpression{}, errInvalidCron
+	}
+	var result cronExpression
+	var err error
+	if result.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return result, err
+	}
+	if result.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return result, err
+	}
+	if result.dayOfMonth, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return result, err
+	}
+	if result.month, err = parseCronField(fields[3], 1, 12, cronMonths); err != nil {
+		return result, err
+	}
+	// Sunday is both 0 and 7
+	if result.dayOfWeek, err = parseCronField(fields[4], 0, 7, cronWeekdays); err != nil {
+		return result, err
+	}
+	if result.dayOfWeek&(1<<7) != 0 {
+		result.dayOfWeek |= 1
+	}
+	result.anyDayOfMonth = fields[2] == "*"
+	result.anyDayOfWeek = fields[4] == "*"
+	return result, nil
+}
+
+// parseCronField reads lists of values, ranges and steps, like "1-5", "*/15" or "mon,wed,fri". Names count from the
+// lowest value.
+func parseCronField(field string, lowest, highest int, names []string) (uint64, error) {
+	parseValue := func(value string) (int, error) {
+		for i, name := range names {
+			if strings.EqualFold(value, name) {
+				return lowest + i, nil
+			}
+		}
+		number, err := strconv.Atoi(value)
+		if err != nil || number < lowest || number > highest {
+			return 0, fmt.Errorf("%q is not between %d and %d", value, lowest, highest)
+		}
+		return number, nil
+	}
+
+	var result uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if slash := strings.Index(part, "/"); slash >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[slash+1:])
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("%q is not a step", part[slash+1:])
+			}
+			part = part[:slash]
+		}
+		from, to := lowest, highest
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if from, err = parseValue(bounds[0]); err != nil {
+				return 0, err
+			}
+			to = from
+			if len(bounds) == 2 {
+				if to, err = parseValue(bounds[1]); err != nil {
+					return 0, err
+				}
+			} else if step > 1 {
+				to = highest
+			}
+			if to < from {
+				return 0, fmt.Errorf("%q goes backwards", part)
+			}
+		}
+		for value := from; value <= to; value += step {
+			result |= 1 << value
+		}
+	}
+	return result, nil
+}
+
+// matches tells whether the expression fires in the minute of the given time. Like cron, a day matches when either
+// the day of the month or the day of the week does, unless one of them is "*".
+func (expression cronExpression) matches(when time.Time) bool {
+	has := func(set uint64, value int) bool { return set&(1<<value) != 0 }
+	if !has(expression.minute, when.Minute()) || !has(expression.hour, when.Hour()) ||
+		!has(expression.month, int(when.Month())) {
+		return false
+	}
+	dayOfMonth := has(expression.dayOfMonth, when.Day())
+	dayOfWeek := has(expression.dayOfWeek, int(when.Weekday()))
+	switch {
+	case expression.anyDayOfMonth:
+		return dayOfWeek
+	case expression.anyDayOfWeek:
+		return dayOfMonth
+	}
+	return dayOfMonth || dayOfWeek
+}
+
+func (schedule Schedule) matches(when time.Time) bool {
+	expression, err := parseCron(schedule.Cron)
+	if err != nil {
+		return false
+	}
+	location, err := time.LoadLocation(schedule.TimeZone)
+	if err != nil {
+		return false
+	}
+	return expression.matches(when.In(location))
+}
+
+// runScheduler starts scheduled sessions at the top of every minute, until the bot exits.
+func runScheduler(session discordSession) {
+	for {
+		now := time.Now()
+		select {
+		case <-botContext.Done():
+			return
+		case <-time.After(now.Truncate(time.Minute).Add(time.Minute).Sub(now)):
+		}
+		startScheduledSessions(session, time.Now())
+	}
+}
+
+// startScheduledSessions plays in every channel with a schedule for this minute, unless its server is already up.
+func startScheduledSessions(session discordSession, now time.Time) {
+	for id, schedules := range store.schedules() {
+		for _, schedule := range schedules {
+			if schedule.matches(now) {
+				runningHandlers.Add(1)
+				go startScheduledSession(session, id, schedule.Guild)
+				break
+			}
+		}
+	}
+}
+
+func startScheduledSession(session discordSession, channelID, guildID Snowflake) {
+	defer runningHandlers.Done()
+	ctx, cancel := context.WithTimeout(botContext, commandTimeout)
+	defer cancel()
+
+	guild := store.guild(guildID.String())
+	channel := store.channel(channelID.String())
+	if channel.dispatcher == nil || !store.startPlaying(channel) {
+		return
+	}
+	defer store.donePlaying(channel)
+	if _, quiet := channel.Shutdown.quietUntil(time.Now()); quiet {
+		log.Printf("Not starting the scheduled session in %s during quiet hours", channelID)
+		return
+	}
+	running, err := serverRunning(ctx, guild, channel)
+	if err != nil {
+		log.Printf("Not starting the scheduled session in %s: %v", channelID, err)
+		return
+	}
+	if running {
+		return
+	}
+
+	// The announcement stands in for a >play, so whatever play says goes in reply to it
+	message, err := session.ChannelMessageSend(channelID.String(), ":calendar: Starting the server for the scheduled session.")
+	if err != nil {
+		log.Printf("Unable to announce the scheduled session in %s: %v", channelID, err)
+		return
+	}
+	message.GuildID = guildID.String()
+	event := messageEvent{ctx, session, &discordgo.MessageCreate{Message: message}, nil}
+	if err = channel.dispatcher.play(event); err != nil {
+		log.Printf("Unable to start the scheduled session in %s: %v", channelID, err)
+		_ = event.react(":warning:")
+	}
+}
+
+func (event messageEvent) commandSchedule() error {
+	channel := store.channel(event.message.ChannelID)
+	if len(event.command) == 1 {
+		if len(channel.Schedules) == 0 {
+			return event.reply("Nothing scheduled; " + scheduleUsage)
+		}
+		lines := make([]string, len(channel.Schedules))
+		for i, schedule := range channel.Schedules {
+			lines[i] = fmt.Sprintf("%d: %s %s", i+1, schedule.Cron, schedule.TimeZone)
+		}
+		return event.reply("```\n" + strings.Join(lines, "\n") + "\n```")
+	}
+	user := store.user(event.message.Author.ID)
+	if !user.IsAdmin {
+		return errUnauthorized
+	}
+
+	switch {
+	case event.command[1] == "add" && (len(event.command) == 7 || len(event.command) == 8):
+		cron := strings.Join(event.command[2:7], " ")
+		if _, err := parseCron(cron); err != nil {
+			return event.reply(fmt.Sprintf("Can't read `%s`: %v", cron, err))
+		}
+		timezone := channel.Shutdown.TimeZone
+		if len(event.command) == 8 {
+			timezone = event.command[7]
+		}
+		if timezone == "" {
+			timezone = "UTC"
+		}
+		if _, err := time.LoadLocation(timezone); err != nil {
+			return event.reply(fmt.Sprintf("Unknown time zone `%s`; try something like `Europe/Paris`.", timezone))
+		}
+		storeLock.Lock()
+		channel.Schedules = append(channel.Schedules, Schedule{cron, timezone, sf(event.message.GuildID)})
+		storeLock.Unlock()
+	case event.command[1] == "remove" && len(event.command) == 3:
+		number, err := strconv.Atoi(event.command[2])
+		if err != nil || number < 1 || number > len(channel.Schedules) {
+			return event.reply(scheduleUsage)
+		}
+		storeLock.Lock()
+		channel.Schedules = append(channel.Schedules[:number-1], channel.Schedules[number:]...)
+		storeLock.Unlock()
+	default:
+		return event.reply(scheduleUsage)
+	}
+	store.store()
+	return event.react(":white_check_mark:")
+}
@@ -0,0 +1,123 @@
+package dispatcher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestCronMatches(t *testing.T) {
+	friday := time.Date(2021, time.June, 4, 19, 30, 0, 0, time.UTC)
+	for _, test := range []struct {
+		cron    string
+		when    time.Time
+		matches bool
+	}{
+		{"30 19 * * fri", friday, true},
+		{"30 19 * * 5", friday, true},
+		{"30 19 * * mon-thu", friday, false},
+		{"*/15 19 * * *", friday, true},
+		{"*/20 19 * * *", friday, false},
+		{"0,30 18-20 * jun *", friday, true},
+		{"30 19 1 * sat", friday, false},
+		{"30 19 4 * sat", friday, true}, // either day field
+		{"0 12 * * 7", time.Date(2021, time.June, 6, 12, 0, 0, 0, time.UTC), true},
+		{"0 12 * * 0", time.Date(2021, time.June, 6, 12, 0, 0, 0, time.UTC), true},
+	} {
+		expression, err := parseCron(test.cron)
+		if err != nil {
+			t.Errorf("%s: %v", test.cron, err)
+			continue
+		}
+		if expression.matches(test.when) != test.matches {
+			t.Errorf("%s matching %v should be %v", test.cron, test.when, test.matches)
+		}
+	}
+}
+
+func TestCronInvalid(t *testing.T) {
+	for _, cron := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "5-1 * * * *",
+		"*/0 * * * *", "* * * * funday"} {
+		if _, err := parseCron(cron); err == nil {
+			t.Errorf("%q should not parse", cron)
+		}
+	}
+}
+
+func TestScheduleTimeZone(t *testing.T) {
+	schedule := Schedule{Cron: "0 20 * * *", TimeZone: "Europe/Paris"}
+	if !schedule.matches(time.Date(2021, time.June, 4, 18, 0, 0, 0, time.UTC)) {
+		t.Error("20:00 in Paris is 18:00 UTC in summer")
+	}
+	if schedule.matches(time.Date(2021, time.June, 4, 20, 0, 0, 0, time.UTC)) {
+		t.Error("20:00 UTC is not 20:00 in Paris")
+	}
+}
+
+func TestScheduledPlay(t *testing.T) {
+	_, fakeEc2, session := setupFakes(t)
+	sendMessage(t, session, ">aws us-east-1 "+testBucket)
+	sendMessage(t, session, ">setup factorio")
+	sendMessage(t, session, ">schedule add 30 19 * * fri Europe/Paris")
+
+	thursday := time.Date(2021, time.June, 3, 17, 30, 0, 0, time.UTC)
+	startScheduledSessions(session, thursday)
+	runningHandlers.Wait()
+	if len(fakeEc2.UserData) != 0 {
+		t.Fatal("started a server on a Thursday")
+	}
+	friday := time.Date(2021, time.June, 4, 17, 30, 0, 0, time.UTC)
+	startScheduledSessions(session, friday)
+	runningHandlers.Wait()
+	if len(fakeEc2.UserData) != 1 {
+		t.Fatalf("started %d servers on Friday night", len(fakeEc2.UserData))
+	}
+	// Still running, so nothing more to start
+	startScheduledSessions(session, friday)
+	runningHandlers.Wait()
+	if len(fakeEc2.UserData) != 1 {
+		t.Errorf("started a second server while the first one runs")
+	}
+
+	sendMessage(t, session, ">schedule remove 1")
+	if len(store.channel(testChannelID).Schedules) != 0 {
+		t.Error("the schedule is still there")
+	}
+}
+
+// TestScheduledPlayAlongsideCommands starts a scheduled session while commands change the same channel and guild and
+// the store gets saved, for the race detector to look at.
+func TestScheduledPlayAlongsideCommands(t *testing.T) {
+	_, fakeEc2, session := setupFakes(t)
+	sendMessage(t, session, ">aws us-east-1 "+testBucket)
+	sendMessage(t, session, ">setup factorio")
+	sendMessage(t, session, ">cost budget 100")
+	sendMessage(t, session, ">schedule add 30 19 * * fri UTC")
+
+	commands := make(chan struct{})
+	go func() {
+		defer close(commands)
+		for _, content := range []string{">cost", ">spot on", ">timeouts idle 2m", ">cost", ">spot off", ">link alice"} {
+			handleMessage(session, testBotID, &discordgo.MessageCreate{Message: &discordgo.Message{
+				ID:        content,
+				ChannelID: testChannelID,
+				GuildID:   testGuildID,
+				Author:    &discordgo.User{ID: testAdminID},
+				Content:   content,
+			}})
+			if _, err := marshalStore(); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	startScheduledSessions(session, time.Date(2021, time.June, 4, 19, 30, 0, 0, time.UTC))
+	<-commands
+	runningHandlers.Wait()
+	if len(fakeEc2.UserData) != 1 {
+		t.Errorf("started %d servers", len(fakeEc2.UserData))
+	}
+	if total, _ := store.guild(testGuildID).monthToDate(time.Now()); total < 0 {
+		t.Errorf("spent %v", total)
+	}
+}
@@ -0,0 +1,188 @@
+package dispatcher
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ServerSettings are the parts of Factorio's server-settings.json a channel can change. Zero means game default.
+type ServerSettings struct {
+	Name             string
+	Description      string
+	Password         string
+	Visibility       string
+	MaxPlayers       int
+	AutosaveInterval int
+	AfkKick          int
+	Admins           []string
+}
+
+var serverVisibilities = []string{"hidden", "lan", "public"}
+
+const serverSettingsUsage = "Expected: `>server name|description|password|visibility|players|autosave|afk|admins [value]`"
+
+// file renders server-settings.json. The launcher adds the mod portal credentials that public games need, so they
+// never end up in the state.
+func (settings ServerSettings) file(channelName string) jsObj {
+	name := settings.Name
+	if name == "" {
+		name = channelName
+	}
+	autosave := settings.AutosaveInterval
+	if autosave == 0 {
+		autosave = 10
+	}
+	return jsObj{
+		"name":                           name,
+		"description":                    settings.Description,
+		"tags":                           []string{"narval"},
+		"max_players":                    settings.MaxPlayers,
+		"visibility":                     jsObj{"public": settings.Visibility == "public", "lan": settings.Visibility != "hidden"},
+		"game_password":                  settings.Password,
+		"require_user_verification":      true,
+		"autosave_interval":              autosave,
+		"autosave_slots":                 5,
+		"afk_autokick_interval":          settings.AfkKick,
+		"auto_pause":                     true,
+		"only_admins_can_pause_the_game": true,
+		"autosave_only_on_server":        true,
+	}
+}
+
+func (settings ServerSettings) String() string {
+	show := func(value string, fallback string) string {
+		if value == "" {
+			return fallback
+		}
+		return value
+	}
+	minutes := func(value int, fallback string) string {
+		if value == 0 {
+			return fallback
+		}
+		return fmt.Sprintf("%d minutes", value)
+	}
+	password := "none"
+	if settings.Password != "" {
+		password = "set"
+	}
+	players := "unlimited"
+	if settings.MaxPlayers > 0 {
+		players = strconv.Itoa(settings.MaxPlayers)
+	}
+	return strings.Join([]string{
+		"name: " + show(settings.Name, "the channel's name"),
+		"description: " + show(settings.Description, "none"),
+		"password: " + password,
+		"visibility: " + show(settings.Visibility, "lan"),
+		"players: " + players,
+		"autosave: " + minutes(settings.AutosaveInterval, "10 minutes"),
+		"afk: " + minutes(settings.AfkKick, "never"),
+		"admins: " + show(strings.Join(settings.Admins, ", "), "none"),
+	}, "\n")
+}
+
+// set changes one setting, telling what was wrong with the value if it can't. An empty value resets it.
+func (settings *ServerSettings) set(name, value string) string {
+	number := func(target *int, low, high int, unit string) string {
+		if value == "" {
+			*target = 0
+			return ""
+		}
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < low || parsed > high {
+			return fmt.Sprintf("Expected %s from %d to %d", unit, low, high)
+		}
+		*target = parsed
+		return ""
+	}
+	switch name {
+	case "name":
+		if len(value) > 50 {
+			return "That name is too long"
+		}
+		settings.Name = value
+	case "description":
+		if len(value) > 500 {
+			return "That description is too long"
+		}
+		settings.Description = value
+	case "password":
+		settings.Password = value
+	case "visibility":
+		if value != "" && !containsString(serverVisibilities, value) {
+			return "Visibility is one of " + strings.Join(serverVisibilities, ", ")
+		}
+		settings.Visibility = value
+	case "players":
+		return number(&settings.MaxPlayers, 1, 65535, "a number of players")
+	case "autosave":
+		return number(&settings.AutosaveInterval, 1, 1440, "minutes")
+	case "afk":
+		return number(&settings.AfkKick, 1, 1440, "minutes")
+	case "admins":
+		settings.Admins = nil
+		for _, admin := range strings.Split(value, ",") {
+			if admin = strings.TrimSpace(admin); admin != "" {
+				settings.Admins = append(settings.Admins, admin)
+			}
+		}
+	default:
+		return serverSettingsUsage
+	}
+	return ""
+}
+
+func (event messageEvent) commandServer() error {
+	channel := store.channel(event.message.ChannelID)
+	if len(event.command) == 1 {
+		return event.reply("```\n" + channel.ServerSettings.String() + "\n```")
+	}
+	user := store.user(event.message.Author.ID)
+	if !user.IsAdmin {
+		return errUnauthorized
+	}
+	updated := channel.ServerSettings
+	field := event.command[1]
+	if problem := updated.set(field, strings.Join(event.command[2:], " ")); problem != "" {
+		return event.reply(problem)
+	}
+	storeLock.Lock()
+	channel.ServerSettings = updated
+	storeLock.Unlock()
+	store.store()
+	err := event.putServerSettings(channel, updated)
+	if err != nil {
+		return err
+	}
+
+	if field == "password" {
+		// Don't leave the password around for everyone to read
+		err = event.session.ChannelMessageDelete(event.message.ChannelID, event.message.ID)
+		if err != nil {
+			return err
+		}
+		_, err = event.session.ChannelMessageSend(event.message.ChannelID, "Changed the password :white_check_mark:")
+		return err
+	}
+	return event.react(":white_check_mark:")
+}
+
+func (event messageEvent) putServerSettings(channel *ChannelStore, settings ServerSettings) error {
+	channelName := "Narval"
+	if discordChannel, err := event.session.Channel(event.message.ChannelID); err == nil {
+		channelName = discordChannel.Name
+	}
+	buffer, err := json.MarshalIndent(settings.file(channelName), "", "  ")
+	if err != nil {
+		return err
+	}
+	err = event.putS3file("state/server-settings.json", bytes.NewReader(buffer))
+	if err != nil {
+		return err
+	}
+	return event.putPlayerLists(channel)
+}
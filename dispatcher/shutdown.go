@@ -0,0 +1,129 @@
+package dispatcher
+
+import (
+	"fmt"
+	"narval/launchers"
+	"strings"
+	"time"
+)
+
+// ShutdownSettings are the per-channel overrides of the launcher's shutdown policy. Empty means launcher default.
+type ShutdownSettings struct {
+	StartupGrace string
+	IdleGrace    string
+	MaxSession   string
+	QuietHours   string
+	TimeZone     string
+}
+
+func (settings ShutdownSettings) addVariables(variables map[string]string) {
+	for name, value := range map[string]string{
+		"STARTUP_GRACE":  settings.StartupGrace,
+		"SHUTDOWN_GRACE": settings.IdleGrace,
+		"MAX_SESSION":    settings.MaxSession,
+		"QUIET_HOURS":    settings.QuietHours,
+		"TIMEZONE":       settings.TimeZone,
+	} {
+		if value != "" {
+			variables[name] = value
+		}
+	}
+}
+
+func (settings *ShutdownSettings) field(name string) *string {
+	switch name {
+	case "startup":
+		return &settings.StartupGrace
+	case "idle":
+		return &settings.IdleGrace
+	case "max":
+		return &settings.MaxSession
+	case "quiet":
+		return &settings.QuietHours
+	case "timezone":
+		return &settings.TimeZone
+	}
+	return nil
+}
+
+func (settings ShutdownSettings) String() string {
+	show := func(value string) string {
+		if value == "" {
+			return "default"
+		}
+		return value
+	}
+	return strings.Join([]string{
+		"startup: " + show(settings.StartupGrace),
+		"idle: " + show(settings.IdleGrace),
+		"max: " + show(settings.MaxSession),
+		"quiet: " + show(settings.QuietHours),
+		"timezone: " + show(settings.TimeZone),
+	}, "\n")
+}
+
+func (event messageEvent) commandTimeouts() error {
+	channel := store.channel(event.message.ChannelID)
+	if len(event.command) == 1 {
+		return event.reply("```\n" + channel.Shutdown.String() + "\n```")
+	}
+	user := store.user(event.message.Author.ID)
+	if !user.IsAdmin {
+		return errUnauthorized
+	}
+	if len(event.command) > 3 {
+		return event.reply("Expected: `>timeouts startup|idle|max|quiet|timezone [value]`")
+	}
+	updated := channel.Shutdown
+	field := updated.field(event.command[1])
+	if field == nil {
+		return event.reply("Expected: `>timeouts startup|idle|max|quiet|timezone [value]`")
+	}
+	*field = ""
+	if len(event.command) == 3 {
+		*field = event.command[2]
+	}
+	if err := updated.validate(); err != nil {
+		return event.reply(fmt.Sprintf("Not valid: %v", err))
+	}
+	storeLock.Lock()
+	channel.Shutdown = updated
+	storeLock.Unlock()
+	store.store()
+	return event.react(":white_check_mark:")
+}
+
+func (settings ShutdownSettings) validate() error {
+	for _, value := range []string{settings.StartupGrace, settings.IdleGrace, settings.MaxSession} {
+		if value == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		if duration <= 0 {
+			return errNotPositive
+		}
+	}
+	if _, err := time.LoadLocation(settings.TimeZone); err != nil {
+		return err
+	}
+	if settings.QuietHours != "" {
+		_, err := launchers.ParseQuietHours(settings.QuietHours, settings.TimeZone)
+		return err
+	}
+	return nil
+}
+
+// quietUntil tells when the channel's quiet hours are over, if the given moment is in them.
+func (settings ShutdownSettings) quietUntil(now time.Time) (time.Time, bool) {
+	if settings.QuietHours == "" {
+		return time.Time{}, false
+	}
+	quiet, err := launchers.ParseQuietHours(settings.QuietHours, settings.TimeZone)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return quiet.End(now)
+}
@@ -0,0 +1,77 @@
+package dispatcher
+
+import (
+	"fmt"
+	"log"
+)
+
+const spotUsage = "Expected: `>spot off|on|relaunch`"
+
+// commandSpot chooses between on-demand and spot instances, which cost a fraction as much but can be taken back with a
+// two minute warning. The budget still counts them at the on-demand price, so it errs on the safe side.
+func (event messageEvent) commandSpot() error {
+	channel := store.channel(event.message.ChannelID)
+	if len(event.command) == 1 {
+		switch {
+		case channel.SpotRelaunch:
+			return event.reply("Servers run on spot instances, and come back on demand when AWS takes them back.")
+		case channel.Spot:
+			return event.reply("Servers run on spot instances.")
+		}
+		return event.reply("Servers run on demand.")
+	}
+	user := store.user(event.message.Author.ID)
+	if !user.IsAdmin {
+		return errUnauthorized
+	}
+	if len(event.command) != 2 {
+		return event.reply(spotUsage)
+	}
+	var spot, relaunch bool
+	switch event.command[1] {
+	case "off":
+	case "on":
+		spot = true
+	case "relaunch":
+		spot, relaunch = true, true
+	default:
+		return event.reply(spotUsage)
+	}
+	storeLock.Lock()
+	channel.Spot, channel.SpotRelaunch = spot, relaunch
+	storeLock.Unlock()
+	store.store()
+	return event.react(":white_check_mark:")
+}
+
+// spotReclaimed starts the channel's server again on demand, if it's supposed to, once the launcher says AWS took
+// the spot instance back and the game is saved.
+func (event messageEvent) spotReclaimed(channel *ChannelStore) error {
+	if !channel.SpotRelaunch || channel.dispatcher == nil {
+		return nil
+	}
+	// The reclaimed instance is still going away, so there's no point asking whether it runs
+	if !store.startPlaying(channel) {
+		return nil
+	}
+	defer store.donePlaying(channel)
+	log.Printf("Relaunching %s on demand", channel.Instance)
+	_, err := event.session.ChannelMessageSend(event.message.ChannelID, "Starting the server again, on demand this time.")
+	if err != nil {
+		return err
+	}
+	storeLock.Lock()
+	channel.onDemand = true
+	storeLock.Unlock()
+	defer func() {
+		storeLock.Lock()
+		channel.onDemand = false
+		storeLock.Unlock()
+	}()
+	err = channel.dispatcher.play(event)
+	if err != nil {
+		_, _ = event.session.ChannelMessageSend(event.message.ChannelID,
+			fmt.Sprintf(":warning: Unable to start the server again: %v", err))
+	}
+	return err
+}
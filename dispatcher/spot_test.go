@@ -0,0 +1,61 @@
+package dispatcher
+
+import (
+	"context"
+	"fmt"
+	"narval/launchers"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestSpotRelaunch(t *testing.T) {
+	_, fakeEc2, session := setupFakes(t)
+	sendMessage(t, session, ">aws us-east-1 "+testBucket)
+	sendMessage(t, session, ">setup factorio")
+	sendMessage(t, session, ">spot relaunch")
+	sendMessage(t, session, ">play")
+	if len(fakeEc2.Spot) != 1 || !fakeEc2.Spot[0] {
+		t.Fatalf("expected a spot instance, got %v", fakeEc2.Spot)
+	}
+	if userDataVariables(fakeEc2.UserData[0])["SPOT"] == "" {
+		t.Error("the launcher wouldn't watch for interruptions")
+	}
+
+	channel := store.channel(testChannelID)
+	fromLauncher := func(content string) {
+		handleMessage(session, testBotID, &discordgo.MessageCreate{Message: &discordgo.Message{
+			ID:        "2000",
+			ChannelID: testChannelID,
+			GuildID:   testGuildID,
+			WebhookID: channel.WebhookID,
+			Author:    &discordgo.User{ID: channel.WebhookID, Bot: true},
+			Content:   content,
+		}})
+	}
+	fromLauncher("`<mallory>` " + launchers.SpotReclaimedMessage)
+	if len(fakeEc2.Spot) != 1 {
+		t.Fatal("game chat started another instance")
+	}
+	fromLauncher(launchers.SpotReclaimedMessage)
+	if len(fakeEc2.Spot) != 2 || fakeEc2.Spot[1] {
+		t.Fatalf("expected an on-demand instance after the spot one, got %v", fakeEc2.Spot)
+	}
+	if userDataVariables(fakeEc2.UserData[1])["SPOT"] != "" {
+		t.Error("the on-demand instance would watch for interruptions")
+	}
+	if channel.Instance != fmt.Sprintf("i-%017d", 2) {
+		t.Errorf("the channel lost track of its instance: %s", channel.Instance)
+	}
+
+	sendMessage(t, session, ">play")
+	if len(fakeEc2.Spot) != 2 {
+		t.Fatal("started another server while the on-demand one runs")
+	}
+	_, _ = fakeEc2.TerminateInstances(context.Background(), &ec2.TerminateInstancesInput{InstanceIds: []string{channel.Instance}})
+	sendMessage(t, session, ">play")
+	if len(fakeEc2.Spot) != 3 || !fakeEc2.Spot[2] {
+		t.Errorf("expected the next session back on spot, got %v", fakeEc2.Spot)
+	}
+}
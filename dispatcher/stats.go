@@ -0,0 +1,96 @@
+package dispatcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/bwmarrin/discordgo"
+	"narval/launchers"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// channelStats is every session summary the launcher left for the channel, added up.
+type channelStats struct {
+	sessions    int
+	first       time.Time
+	peakPlayers int
+	playTimes   map[launchers.User]time.Duration
+}
+
+const statsTopPlayers = 10
+
+func (stats *channelStats) add(summary launchers.SessionSummary) {
+	stats.sessions++
+	if stats.first.IsZero() || summary.Start.Before(stats.first) {
+		stats.first = summary.Start
+	}
+	if summary.PeakPlayers > stats.peakPlayers {
+		stats.peakPlayers = summary.PeakPlayers
+	}
+	for player, duration := range summary.PlayTimes() {
+		stats.playTimes[player] += duration
+	}
+}
+
+func (stats *channelStats) sessionsPerWeek(now time.Time) float64 {
+	weeks := now.Sub(stats.first).Hours() / (7 * 24)
+	if weeks < 1 {
+		weeks = 1
+	}
+	return float64(stats.sessions) / weeks
+}
+
+func (stats *channelStats) String() string {
+	players := make([]launchers.User, 0, len(stats.playTimes))
+	for player := range stats.playTimes {
+		players = append(players, player)
+	}
+	sort.Slice(players, func(i, j int) bool { return stats.playTimes[players[i]] > stats.playTimes[players[j]] })
+	if len(players) > statsTopPlayers {
+		players = players[:statsTopPlayers]
+	}
+
+	links := linkedPlayers()
+	lines := []string{fmt.Sprintf("%d sessions since %s, %.1f a week; at most %d playing at once.",
+		stats.sessions, stats.first.Format("2006-01-02"), stats.sessionsPerWeek(time.Now()), stats.peakPlayers)}
+	for _, player := range players {
+		name := fmt.Sprintf("`%s`", player)
+		if id, found := links[string(player)]; found {
+			name += fmt.Sprintf(" <@%s>", id)
+		}
+		lines = append(lines, fmt.Sprintf("%s: %.1f hours", name, stats.playTimes[player].Hours()))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (event messageEvent) commandStats() error {
+	guild := store.guild(event.message.GuildID)
+	keys, err := s3list(event.ctx, guild, path.Join(event.message.ChannelID, "stats")+"/")
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return event.reply("Nobody played here yet.")
+	}
+
+	stats := channelStats{playTimes: map[launchers.User]time.Duration{}}
+	for _, key := range keys {
+		buffer, err := s3download(event.ctx, guild, key)
+		if err != nil {
+			return err
+		}
+		var summary launchers.SessionSummary
+		if err = json.Unmarshal(buffer, &summary); err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		stats.add(summary)
+	}
+	_, err = event.session.ChannelMessageSendComplex(event.message.ChannelID, &discordgo.MessageSend{
+		Content:         stats.String(),
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+		Reference:       event.message.Reference(),
+	})
+	return err
+}
@@ -5,8 +5,10 @@ import (
 	"encoding/json"
 	"errors"
 	"log"
+	"narval/launchers"
 	"net/url"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/natefinch/atomic"
@@ -21,27 +23,61 @@ type Store struct {
 type UserStore struct {
 	id           Snowflake
 	IsAdmin      bool
+	FactorioName string
 	confirmation string
+	linkName     string
+	linkCode     string
 }
 
 type ChannelStore struct {
-	id            Snowflake
-	SetupComplete bool
-	Game          string
-	Prefix        string
-	dispatcher    dispatcher
-	session       string
+	id               Snowflake
+	SetupComplete    bool
+	Game             string
+	Prefix           string
+	GameVersion      string
+	Shutdown         ShutdownSettings
+	Map              MapSettings
+	ServerSettings   ServerSettings
+	AdminRole        string
+	PlayerRole       string
+	WebhookID        string
+	WebhookToken     string
+	Instance         string
+	InstanceProvider string
+	ElasticIP        string
+	DnsName          string
+	Spot             bool
+	SpotRelaunch     bool
+	Schedules        []Schedule
+	DockerPort       int
+	dispatcher       dispatcher
+	onDemand         bool
+	playing          bool
+	session          string
+	saveMods         []launchers.SaveMod
 }
 
 type GuildStore struct {
-	id     Snowflake
-	Bucket string
-	Region string
+	id               Snowflake
+	Bucket           string
+	Region           string
+	S3Endpoint       launchers.S3Endpoint
+	FactorioUsername string
+	FactorioToken    string
+	MonthlyBudget    float64
+	Provider         string
+	Distro           string
+	HostedZone       string
+	Sessions         []SessionCost
 }
 
 var allDispatchers = map[string]dispatcher{}
 
 var store Store
+
+// storeLock guards the store's maps and what's in them, which commands, the scheduler and keepStoring all get at once.
+// Changes to anything stored, and reads of what others change, take it; nothing slow is done holding it.
+var storeLock sync.Mutex
 var storeUrl url.URL
 var storeThrottle = make(chan struct{}, 200)
 
@@ -90,7 +126,7 @@ func keepStoring() {
 		time.Sleep(1 * time.Second)
 		<-storeThrottle
 
-		buffer, err := json.Marshal(store)
+		buffer, err := marshalStore()
 		if err != nil {
 			log.Printf("Unable to marshal (%s): %v", err, store)
 			continue
@@ -103,7 +139,15 @@ func keepStoring() {
 	}
 }
 
+func marshalStore() ([]byte, error) {
+	storeLock.Lock()
+	defer storeLock.Unlock()
+	return json.Marshal(store)
+}
+
 func (store Store) user(id string) *UserStore {
+	storeLock.Lock()
+	defer storeLock.Unlock()
 	flake := sf(id)
 	user, found := store.Users[flake]
 	if !found {
@@ -114,6 +158,8 @@ func (store Store) user(id string) *UserStore {
 }
 
 func (store Store) channel(id string) *ChannelStore {
+	storeLock.Lock()
+	defer storeLock.Unlock()
 	flake := sf(id)
 	channel, found := store.Channels[flake]
 	if !found {
@@ -127,6 +173,8 @@ func (store Store) channel(id string) *ChannelStore {
 }
 
 func (store Store) guild(id string) *GuildStore {
+	storeLock.Lock()
+	defer storeLock.Unlock()
 	flake := sf(id)
 	guild, found := store.Guilds[flake]
 	if !found {
@@ -135,3 +183,53 @@ func (store Store) guild(id string) *GuildStore {
 	}
 	return guild
 }
+
+// startPlaying claims the channel for starting its server, so a schedule and a >play can't both start one; false if
+// something else already is.
+func (store Store) startPlaying(channel *ChannelStore) bool {
+	storeLock.Lock()
+	defer storeLock.Unlock()
+	if channel.playing {
+		return false
+	}
+	channel.playing = true
+	return true
+}
+
+func (store Store) donePlaying(channel *ChannelStore) {
+	storeLock.Lock()
+	channel.playing = false
+	storeLock.Unlock()
+}
+
+// schedules copies out the schedules of every channel that has some.
+func (store Store) schedules() map[Snowflake][]Schedule {
+	storeLock.Lock()
+	defer storeLock.Unlock()
+	result := map[Snowflake][]Schedule{}
+	for id, channel := range store.Channels {
+		if len(channel.Schedules) > 0 {
+			result[id] = append([]Schedule(nil), channel.Schedules...)
+		}
+	}
+	return result
+}
+
+// dockerPort tells the host port of the channel's docker containers, picking one no other channel has the first time.
+func (store Store) dockerPort(channel *ChannelStore) int {
+	storeLock.Lock()
+	defer storeLock.Unlock()
+	if channel.DockerPort != 0 {
+		return channel.DockerPort
+	}
+	taken := map[int]bool{}
+	for _, other := range store.Channels {
+		taken[other.DockerPort] = true
+	}
+	port := dockerFirstPort
+	for taken[port] {
+		port++
+	}
+	channel.DockerPort = port
+	return port
+}
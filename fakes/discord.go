@@ -0,0 +1,101 @@
+package fakes
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Discord stands in for a bot's session, remembering everything the bot said and did.
+type Discord struct {
+	lock      sync.Mutex
+	messages  int
+	Sent      []*discordgo.Message
+	Reactions []string
+	Deleted   []string
+	Typing    int
+	Webhooks  []*discordgo.Webhook
+	// Roles of guild members, by user ID.
+	Roles map[string][]string
+}
+
+func NewDiscord() *Discord {
+	return &Discord{Roles: map[string][]string{}}
+}
+
+// Said tells the content of every message sent so far.
+func (fake *Discord) Said() []string {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	result := make([]string, len(fake.Sent))
+	for i, message := range fake.Sent {
+		result[i] = message.Content
+	}
+	return result
+}
+
+func (fake *Discord) send(channelID string, content string) *discordgo.Message {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	fake.messages++
+	message := &discordgo.Message{ID: fmt.Sprint(fake.messages), ChannelID: channelID, Content: content}
+	fake.Sent = append(fake.Sent, message)
+	return message
+}
+
+func (fake *Discord) ChannelMessageSend(channelID string, content string) (*discordgo.Message, error) {
+	return fake.send(channelID, content), nil
+}
+
+func (fake *Discord) ChannelMessageSendReply(channelID string, content string, _ *discordgo.MessageReference) (*discordgo.Message, error) {
+	return fake.send(channelID, content), nil
+}
+
+func (fake *Discord) ChannelMessageSendComplex(channelID string, data *discordgo.MessageSend) (*discordgo.Message, error) {
+	return fake.send(channelID, data.Content), nil
+}
+
+func (fake *Discord) ChannelMessageDelete(_, messageID string) error {
+	fake.lock.Lock()
+	fake.Deleted = append(fake.Deleted, messageID)
+	fake.lock.Unlock()
+	return nil
+}
+
+func (fake *Discord) ChannelTyping(string) error {
+	fake.lock.Lock()
+	fake.Typing++
+	fake.lock.Unlock()
+	return nil
+}
+
+func (fake *Discord) MessageReactionAdd(_, _, emojiID string) error {
+	fake.lock.Lock()
+	fake.Reactions = append(fake.Reactions, emojiID)
+	fake.lock.Unlock()
+	return nil
+}
+
+func (fake *Discord) WebhookCreate(channelID, name, _ string) (*discordgo.Webhook, error) {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	webhook := &discordgo.Webhook{
+		ID:        fmt.Sprintf("%d", 900000+len(fake.Webhooks)),
+		ChannelID: channelID,
+		Name:      name,
+		Token:     fmt.Sprintf("token-%d", len(fake.Webhooks)),
+	}
+	fake.Webhooks = append(fake.Webhooks, webhook)
+	return webhook, nil
+}
+
+func (fake *Discord) GuildMember(guildID, userID string) (*discordgo.Member, error) {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	return &discordgo.Member{GuildID: guildID, User: &discordgo.User{ID: userID}, Roles: fake.Roles[userID]}, nil
+}
+
+func (fake *Discord) Channel(channelID string) (*discordgo.Channel, error) {
+	return &discordgo.Channel{ID: channelID, Name: "channel-" + channelID, Type: discordgo.ChannelTypeGuildText}, nil
+}
@@ -0,0 +1,125 @@
+package fakes
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// EC2 pretends to run instances, remembering their user data so a test can play the part of the instance.
+type EC2 struct {
+	lock      sync.Mutex
+	instances map[string]types.InstanceStateName
+	UserData  []string
+	// Spot tells, for every instance, whether it was asked for on the spot market.
+	Spot []bool
+	// OnRun, if set, is told the decoded user data of every instance as it starts.
+	OnRun func(userData string)
+	// Addresses are the public IPs of elastic IPs, by allocation ID, and Associations the instances they went to.
+	Addresses    map[string]string
+	Associations map[string]string
+}
+
+// FakePublicIp is the address every instance gets until an elastic IP replaces it.
+const FakePublicIp = "192.0.2.10"
+
+func NewEC2() *EC2 {
+	return &EC2{
+		instances:    map[string]types.InstanceStateName{},
+		Addresses:    map[string]string{},
+		Associations: map[string]string{},
+	}
+}
+
+func (fake *EC2) RunInstances(_ context.Context, input *ec2.RunInstancesInput, _ ...func(*ec2.Options)) (*ec2.RunInstancesOutput, error) {
+	userData, err := base64.StdEncoding.DecodeString(aws.ToString(input.UserData))
+	if err != nil {
+		return nil, err
+	}
+	fake.lock.Lock()
+	id := fmt.Sprintf("i-%017d", len(fake.instances)+1)
+	fake.instances[id] = types.InstanceStateNameRunning
+	fake.UserData = append(fake.UserData, string(userData))
+	fake.Spot = append(fake.Spot, input.InstanceMarketOptions != nil && input.InstanceMarketOptions.MarketType == types.MarketTypeSpot)
+	fake.lock.Unlock()
+	if fake.OnRun != nil {
+		fake.OnRun(string(userData))
+	}
+	return &ec2.RunInstancesOutput{Instances: []types.Instance{{
+		InstanceId:   aws.String(id),
+		ImageId:      input.ImageId,
+		InstanceType: input.InstanceType,
+		State:        &types.InstanceState{Name: types.InstanceStateNamePending},
+	}}}, nil
+}
+
+func (fake *EC2) DescribeInstances(_ context.Context, input *ec2.DescribeInstancesInput, _ ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error) {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	var instances []types.Instance
+	for _, id := range input.InstanceIds {
+		if state, found := fake.instances[id]; found {
+			instances = append(instances, types.Instance{
+				InstanceId:      aws.String(id),
+				State:           &types.InstanceState{Name: state},
+				PublicIpAddress: aws.String(FakePublicIp),
+			})
+		}
+	}
+	return &ec2.DescribeInstancesOutput{Reservations: []types.Reservation{{Instances: instances}}}, nil
+}
+
+func (fake *EC2) TerminateInstances(_ context.Context, input *ec2.TerminateInstancesInput, _ ...func(*ec2.Options)) (*ec2.TerminateInstancesOutput, error) {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	for _, id := range input.InstanceIds {
+		if _, found := fake.instances[id]; found {
+			fake.instances[id] = types.InstanceStateNameTerminated
+		}
+	}
+	return &ec2.TerminateInstancesOutput{}, nil
+}
+
+// DescribeImages knows a single image, whatever the filters.
+func (fake *EC2) DescribeImages(context.Context, *ec2.DescribeImagesInput, ...func(*ec2.Options)) (*ec2.DescribeImagesOutput, error) {
+	return &ec2.DescribeImagesOutput{Images: []types.Image{{
+		ImageId:      aws.String("ami-00000000000000001"),
+		Name:         aws.String("al2023-ami-2023.6.20241010.0-kernel-6.1-x86_64"),
+		CreationDate: aws.String("2021-01-01T00:00:00.000Z"),
+	}}}, nil
+}
+
+func (fake *EC2) DescribeAddresses(_ context.Context, input *ec2.DescribeAddressesInput, _ ...func(*ec2.Options)) (*ec2.DescribeAddressesOutput, error) {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	output := &ec2.DescribeAddressesOutput{}
+	for _, allocation := range input.AllocationIds {
+		ip, found := fake.Addresses[allocation]
+		if !found {
+			return nil, fmt.Errorf("InvalidAllocationID.NotFound: %s", allocation)
+		}
+		address := types.Address{AllocationId: aws.String(allocation), PublicIp: aws.String(ip)}
+		if instance, associated := fake.Associations[allocation]; associated {
+			address.InstanceId = aws.String(instance)
+			address.AssociationId = aws.String("eipassoc-" + allocation)
+		}
+		output.Addresses = append(output.Addresses, address)
+	}
+	return output, nil
+}
+
+func (fake *EC2) AssociateAddress(_ context.Context, input *ec2.AssociateAddressInput, _ ...func(*ec2.Options)) (*ec2.AssociateAddressOutput, error) {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	allocation := aws.ToString(input.AllocationId)
+	if _, found := fake.Addresses[allocation]; !found {
+		return nil, fmt.Errorf("InvalidAllocationID.NotFound: %s", allocation)
+	}
+	fake.Associations[allocation] = aws.ToString(input.InstanceId)
+	return &ec2.AssociateAddressOutput{AssociationId: aws.String("eipassoc-" + allocation)}, nil
+}
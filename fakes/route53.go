@@ -0,0 +1,77 @@
+package fakes
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+)
+
+// Route53 keeps the records of hosted zones, as "zone name type" to their values separated by spaces. Names are kept
+// without the trailing dot Route 53 adds.
+type Route53 struct {
+	lock    sync.Mutex
+	Records map[string]string
+}
+
+func NewRoute53() *Route53 {
+	return &Route53{Records: map[string]string{}}
+}
+
+// Record tells the values of a record, empty if there is none.
+func (fake *Route53) Record(zone, name string, recordType types.RRType) string {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	return fake.Records[fmt.Sprintf("%s %s %s", zone, name, recordType)]
+}
+
+func (fake *Route53) ChangeResourceRecordSets(_ context.Context, input *route53.ChangeResourceRecordSetsInput, _ ...func(*route53.Options)) (*route53.ChangeResourceRecordSetsOutput, error) {
+	if input.ChangeBatch == nil || len(input.ChangeBatch.Changes) == 0 {
+		return nil, fmt.Errorf("InvalidChangeBatch: no changes")
+	}
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	for _, change := range input.ChangeBatch.Changes {
+		set := change.ResourceRecordSet
+		key := fmt.Sprintf("%s %s %s", aws.ToString(input.HostedZoneId), strings.TrimSuffix(aws.ToString(set.Name), "."),
+			set.Type)
+		var values []string
+		for _, record := range set.ResourceRecords {
+			values = append(values, aws.ToString(record.Value))
+		}
+		switch change.Action {
+		case types.ChangeActionUpsert, types.ChangeActionCreate:
+			fake.Records[key] = strings.Join(values, " ")
+		case types.ChangeActionDelete:
+			if _, found := fake.Records[key]; !found {
+				return nil, fmt.Errorf("InvalidChangeBatch: %s not found", key)
+			}
+			delete(fake.Records, key)
+		}
+	}
+	return &route53.ChangeResourceRecordSetsOutput{ChangeInfo: &types.ChangeInfo{
+		Id:     aws.String("/change/C0000000000001"),
+		Status: types.ChangeStatusPending,
+	}}, nil
+}
+
+// ListResourceRecordSets only knows the record it starts from, where Route 53 would go on with the following ones.
+func (fake *Route53) ListResourceRecordSets(_ context.Context, input *route53.ListResourceRecordSetsInput, _ ...func(*route53.Options)) (*route53.ListResourceRecordSetsOutput, error) {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	name := strings.TrimSuffix(aws.ToString(input.StartRecordName), ".")
+	output := &route53.ListResourceRecordSetsOutput{}
+	values, found := fake.Records[fmt.Sprintf("%s %s %s", aws.ToString(input.HostedZoneId), name, input.StartRecordType)]
+	if found {
+		set := types.ResourceRecordSet{Name: aws.String(name + "."), Type: input.StartRecordType}
+		for _, value := range strings.Split(values, " ") {
+			set.ResourceRecords = append(set.ResourceRecords, types.ResourceRecord{Value: aws.String(value)})
+		}
+		output.ResourceRecordSets = append(output.ResourceRecordSets, set)
+	}
+	return output, nil
+}
@@ -0,0 +1,245 @@
+// Package fakes has in-memory stand-ins for S3, EC2 and Discord, enough for the launcher and the dispatcher to go
+// through a whole session without a network.
+package fakes
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+type s3multipart struct {
+	parts    map[int32][]byte
+	metadata map[string]string
+}
+
+type s3object struct {
+	data     []byte
+	etag     string
+	metadata map[string]string
+	modified time.Time
+}
+
+// S3 keeps objects in memory, across every bucket, keyed by "bucket/key".
+type S3 struct {
+	lock      sync.Mutex
+	objects   map[string]s3object
+	multipart map[string]*s3multipart
+	uploads   int
+}
+
+func NewS3() *S3 {
+	return &S3{objects: map[string]s3object{}, multipart: map[string]*s3multipart{}}
+}
+
+func objectName(bucket, key *string) string {
+	return aws.ToString(bucket) + "/" + aws.ToString(key)
+}
+
+// Object tells the content of an object, and whether there is one.
+func (fake *S3) Object(bucket, key string) ([]byte, bool) {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	object, found := fake.objects[bucket+"/"+key]
+	return object.data, found
+}
+
+// Keys tells every key in a bucket, sorted.
+func (fake *S3) Keys(bucket string) []string {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	var result []string
+	for name := range fake.objects {
+		if strings.HasPrefix(name, bucket+"/") {
+			result = append(result, name[len(bucket)+1:])
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+func (fake *S3) put(name string, data []byte, metadata map[string]string) string {
+	sum := md5.Sum(data)
+	etag := fmt.Sprintf("%q", hex.EncodeToString(sum[:]))
+	copied := map[string]string{}
+	for key, value := range metadata {
+		copied[key] = value
+	}
+	fake.lock.Lock()
+	fake.objects[name] = s3object{data: data, etag: etag, metadata: copied, modified: time.Now()}
+	fake.lock.Unlock()
+	return etag
+}
+
+func (fake *S3) get(bucket, key *string) (s3object, error) {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	object, found := fake.objects[objectName(bucket, key)]
+	if !found {
+		return s3object{}, &types.NoSuchKey{Message: aws.String(aws.ToString(key))}
+	}
+	return object, nil
+}
+
+func (fake *S3) PutObject(_ context.Context, input *s3.PutObjectInput, _ ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	data, err := io.ReadAll(input.Body)
+	if err != nil {
+		return nil, err
+	}
+	etag := fake.put(objectName(input.Bucket, input.Key), data, input.Metadata)
+	return &s3.PutObjectOutput{ETag: &etag}, nil
+}
+
+// GetObject honours IfMatch and the "bytes=a-b" and "bytes=a-" forms of Range, which is all the SDK's downloader uses.
+func (fake *S3) GetObject(_ context.Context, input *s3.GetObjectInput, _ ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	object, err := fake.get(input.Bucket, input.Key)
+	if err != nil {
+		return nil, err
+	}
+	if input.IfMatch != nil && *input.IfMatch != object.etag {
+		return nil, fmt.Errorf("precondition failed: %s changed", aws.ToString(input.Key))
+	}
+	output := &s3.GetObjectOutput{
+		ETag:         aws.String(object.etag),
+		Metadata:     object.metadata,
+		LastModified: aws.Time(object.modified),
+	}
+	data := object.data
+	if input.Range != nil {
+		size := int64(len(data))
+		var start, end int64
+		if _, err := fmt.Sscanf(*input.Range, "bytes=%d-%d", &start, &end); err != nil {
+			if _, err := fmt.Sscanf(*input.Range, "bytes=%d-", &start); err != nil {
+				return nil, fmt.Errorf("unsupported range %q", *input.Range)
+			}
+			end = size - 1
+		}
+		if end >= size {
+			end = size - 1
+		}
+		if start > end {
+			return nil, fmt.Errorf("invalid range %q for %d bytes", *input.Range, size)
+		}
+		data = data[start : end+1]
+		output.ContentRange = aws.String(fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+	}
+	output.ContentLength = int64(len(data))
+	output.Body = io.NopCloser(bytes.NewReader(data))
+	return output, nil
+}
+
+// PresignGetObject makes up a URL that tells the object apart, and that nothing answers.
+func (fake *S3) PresignGetObject(_ context.Context, input *s3.GetObjectInput, options ...func(*s3.PresignOptions)) (*v4.PresignedHTTPRequest, error) {
+	var presign s3.PresignOptions
+	for _, option := range options {
+		option(&presign)
+	}
+	return &v4.PresignedHTTPRequest{
+		URL: fmt.Sprintf("https://s3.invalid/%s/%s?X-Amz-Expires=%d", aws.ToString(input.Bucket), aws.ToString(input.Key),
+			int(presign.Expires.Seconds())),
+		Method: http.MethodGet,
+	}, nil
+}
+
+func (fake *S3) HeadObject(_ context.Context, input *s3.HeadObjectInput, _ ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	object, err := fake.get(input.Bucket, input.Key)
+	if err != nil {
+		return nil, &types.NotFound{}
+	}
+	return &s3.HeadObjectOutput{
+		ContentLength: int64(len(object.data)),
+		ETag:          aws.String(object.etag),
+		Metadata:      object.metadata,
+		LastModified:  aws.Time(object.modified),
+	}, nil
+}
+
+func (fake *S3) DeleteObject(_ context.Context, input *s3.DeleteObjectInput, _ ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	fake.lock.Lock()
+	delete(fake.objects, objectName(input.Bucket, input.Key))
+	fake.lock.Unlock()
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+// ListObjectsV2 answers everything in one page.
+func (fake *S3) ListObjectsV2(_ context.Context, input *s3.ListObjectsV2Input, _ ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	prefix := aws.ToString(input.Prefix)
+	output := &s3.ListObjectsV2Output{Name: input.Bucket, Prefix: input.Prefix}
+	for _, key := range fake.Keys(aws.ToString(input.Bucket)) {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		object, _ := fake.get(input.Bucket, aws.String(key))
+		output.Contents = append(output.Contents, types.Object{
+			Key:          aws.String(key),
+			Size:         int64(len(object.data)),
+			ETag:         aws.String(object.etag),
+			LastModified: aws.Time(object.modified),
+		})
+	}
+	output.KeyCount = int32(len(output.Contents))
+	return output, nil
+}
+
+func (fake *S3) CreateMultipartUpload(_ context.Context, input *s3.CreateMultipartUploadInput, _ ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	fake.lock.Lock()
+	fake.uploads++
+	id := fmt.Sprintf("upload-%d", fake.uploads)
+	fake.multipart[id] = &s3multipart{parts: map[int32][]byte{}, metadata: input.Metadata}
+	fake.lock.Unlock()
+	return &s3.CreateMultipartUploadOutput{Bucket: input.Bucket, Key: input.Key, UploadId: &id}, nil
+}
+
+func (fake *S3) UploadPart(_ context.Context, input *s3.UploadPartInput, _ ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	data, err := io.ReadAll(input.Body)
+	if err != nil {
+		return nil, err
+	}
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	upload, found := fake.multipart[aws.ToString(input.UploadId)]
+	if !found {
+		return nil, &types.NoSuchUpload{}
+	}
+	upload.parts[input.PartNumber] = data
+	sum := md5.Sum(data)
+	return &s3.UploadPartOutput{ETag: aws.String(fmt.Sprintf("%q", hex.EncodeToString(sum[:])))}, nil
+}
+
+func (fake *S3) CompleteMultipartUpload(_ context.Context, input *s3.CompleteMultipartUploadInput, _ ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	fake.lock.Lock()
+	upload, found := fake.multipart[aws.ToString(input.UploadId)]
+	delete(fake.multipart, aws.ToString(input.UploadId))
+	fake.lock.Unlock()
+	if !found {
+		return nil, &types.NoSuchUpload{}
+	}
+	var data []byte
+	if input.MultipartUpload != nil {
+		for _, part := range input.MultipartUpload.Parts {
+			data = append(data, upload.parts[part.PartNumber]...)
+		}
+	}
+	etag := fake.put(objectName(input.Bucket, input.Key), data, upload.metadata)
+	return &s3.CompleteMultipartUploadOutput{Bucket: input.Bucket, Key: input.Key, ETag: &etag}, nil
+}
+
+func (fake *S3) AbortMultipartUpload(_ context.Context, input *s3.AbortMultipartUploadInput, _ ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	fake.lock.Lock()
+	delete(fake.multipart, aws.ToString(input.UploadId))
+	fake.lock.Unlock()
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
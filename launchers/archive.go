@@ -0,0 +1,246 @@
+package launchers
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// ArchiveFormat is what an archive turned out to be, judging by its first bytes rather than its name.
+type ArchiveFormat byte
+
+const (
+	FormatUnknown ArchiveFormat = iota
+	FormatZip
+	FormatTar
+	FormatTarGz
+	FormatTarXz
+	FormatTarZst
+)
+
+const archiveSniffLength = 512
+
+var archiveMagics = []struct {
+	magic  []byte
+	format ArchiveFormat
+}{
+	{[]byte("PK\x03\x04"), FormatZip},
+	{[]byte("PK\x05\x06"), FormatZip}, // empty
+	{[]byte{0x1f, 0x8b}, FormatTarGz},
+	{[]byte{0xfd, '7', 'z', 'X', 'Z', 0x00}, FormatTarXz},
+	{[]byte{0x28, 0xb5, 0x2f, 0xfd}, FormatTarZst},
+}
+
+func (format ArchiveFormat) String() string {
+	switch format {
+	case FormatZip:
+		return "zip"
+	case FormatTar:
+		return "tar"
+	case FormatTarGz:
+		return "tar.gz"
+	case FormatTarXz:
+		return "tar.xz"
+	case FormatTarZst:
+		return "tar.zst"
+	}
+	return "unknown"
+}
+
+// DetectArchiveFormat looks at up to the first 512 bytes of a file. Compressed formats are assumed to hold a tar.
+func DetectArchiveFormat(header []byte) ArchiveFormat {
+	for _, candidate := range archiveMagics {
+		if bytes.HasPrefix(header, candidate.magic) {
+			return candidate.format
+		}
+	}
+	if len(header) >= 262 && string(header[257:262]) == "ustar" {
+		return FormatTar
+	}
+	return FormatUnknown
+}
+
+// IsArchiveName tells whether a file name looks like something the launcher can unpack, before downloading it.
+func IsArchiveName(name string) bool {
+	name = strings.ToLower(name)
+	for _, suffix := range []string{".zip", ".tar", ".tar.gz", ".tgz", ".tar.xz", ".txz", ".tar.zst", ".tzst"} {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func detectArchiveFile(path string) (ArchiveFormat, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return FormatUnknown, err
+	}
+	defer CloseDontCare(file)
+	header := make([]byte, archiveSniffLength)
+	length, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return FormatUnknown, err
+	}
+	return DetectArchiveFormat(header[:length]), nil
+}
+
+// extractArchive unpacks a zip, tar, tar.gz, tar.xz or tar.zst file under pathPrefix, with the same protections as
+// unTar.
+func extractArchive(path, pathPrefix string) error {
+	format, err := detectArchiveFile(path)
+	if err != nil {
+		return err
+	}
+	if format == FormatZip {
+		return unZip(path, pathPrefix, defaultExtractLimits)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer CloseDontCare(file)
+	decompressed, err := decompress(format, bufio.NewReader(file))
+	if err != nil {
+		return err
+	}
+	defer CloseDontCare(decompressed)
+	return unTar(decompressed, pathPrefix)
+}
+
+// decompress peels the compression off a tar stream.
+func decompress(format ArchiveFormat, reader io.Reader) (io.ReadCloser, error) {
+	switch format {
+	case FormatTar:
+		return io.NopCloser(reader), nil
+	case FormatTarGz:
+		return gzip.NewReader(reader)
+	case FormatTarXz:
+		// Un-xz (why the hell do they use xz!)
+		decompressed, err := xz.NewReader(reader)
+		return io.NopCloser(decompressed), err
+	case FormatTarZst:
+		decompressed, err := zstd.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		return decompressed.IOReadCloser(), nil
+	}
+	return nil, fmt.Errorf("%w: %v", errUnknownArchive, format)
+}
+
+func unZip(path, pathPrefix string, limits extractLimits) error {
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer CloseDontCare(archive)
+	job, err := newExtraction(pathPrefix, limits)
+	if err != nil {
+		return err
+	}
+	for _, member := range archive.File {
+		if err = job.extractZipEntry(member); err != nil {
+			break
+		}
+	}
+	finishErr := job.finish()
+	if err != nil {
+		return err
+	}
+	return finishErr
+}
+
+func (job *extraction) extractZipEntry(member *zip.File) error {
+	path, err := job.safePath(member.Name)
+	if err != nil {
+		return err
+	}
+	if path == job.root {
+		return nil
+	}
+	mode := member.Mode()
+	switch {
+	case mode.IsDir():
+		if err = job.makeDir(path); err != nil {
+			return err
+		}
+		job.dirTimes[path] = member.Modified
+		return nil
+	case mode&os.ModeSymlink != 0:
+		if member.UncompressedSize64 > 4096 {
+			return fmt.Errorf("%w: %s has an absurd link", errUnsafePath, member.Name)
+		}
+		target, err := readZipMember(member)
+		if err != nil {
+			return err
+		}
+		return job.makeSymlink(path, string(target))
+	case mode.IsRegular():
+		contents, err := member.Open()
+		if err != nil {
+			return err
+		}
+		defer CloseDontCare(contents)
+		perm := mode.Perm()
+		if perm == 0 {
+			perm = 0644 // Zips made on Windows don't say
+		}
+		return job.writeFile(path, perm, int64(member.UncompressedSize64), member.Modified, contents)
+	}
+	return nil
+}
+
+func readZipMember(member *zip.File) ([]byte, error) {
+	contents, err := member.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer CloseDontCare(contents)
+	return io.ReadAll(contents)
+}
+
+// recompressToZstd rewrites a compressed tar as a tar.zst.
+func recompressToZstd(from, to string) error {
+	format, err := detectArchiveFile(from)
+	if err != nil {
+		return err
+	}
+	input, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer CloseDontCare(input)
+	decompressed, err := decompress(format, bufio.NewReader(input))
+	if err != nil {
+		return err
+	}
+	defer CloseDontCare(decompressed)
+
+	output, err := os.Create(to)
+	if err != nil {
+		return err
+	}
+	defer CloseDontCare(output)
+	compressor, err := zstd.NewWriter(output)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(compressor, decompressed); err != nil {
+		CloseDontCare(compressor)
+		return err
+	}
+	if err = compressor.Close(); err != nil {
+		return err
+	}
+	return output.Sync()
+}
@@ -0,0 +1,72 @@
+package launchers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+func TestExtractArchiveFormats(t *testing.T) {
+	plain := makeTar(t, tarMember{name: "mods/info.json", typeflag: tar.TypeReg, body: "{}"})
+	compressors := map[ArchiveFormat]func(io.Writer) (io.WriteCloser, error){
+		FormatTarGz: func(writer io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(writer), nil },
+		FormatTarXz: func(writer io.Writer) (io.WriteCloser, error) { return xz.NewWriter(writer) },
+		FormatTarZst: func(writer io.Writer) (io.WriteCloser, error) {
+			return zstd.NewWriter(writer)
+		},
+	}
+	archives := map[ArchiveFormat][]byte{FormatTar: plain}
+	for format, compressor := range compressors {
+		var buffer bytes.Buffer
+		writer, err := compressor(&buffer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = writer.Write(plain); err != nil {
+			t.Fatal(err)
+		}
+		if err = writer.Close(); err != nil {
+			t.Fatal(err)
+		}
+		archives[format] = buffer.Bytes()
+	}
+	var zipped bytes.Buffer
+	zipWriter := zip.NewWriter(&zipped)
+	member, err := zipWriter.Create("mods/info.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = member.Write([]byte("{}"))
+	if err = zipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	archives[FormatZip] = zipped.Bytes()
+
+	for format, archive := range archives {
+		t.Run(format.String(), func(t *testing.T) {
+			if detected := DetectArchiveFormat(archive); detected != format {
+				t.Fatalf("detected %v", detected)
+			}
+			path := filepath.Join(t.TempDir(), "upload")
+			if err := os.WriteFile(path, archive, 0644); err != nil {
+				t.Fatal(err)
+			}
+			root := t.TempDir()
+			if err := extractArchive(path, root); err != nil {
+				t.Fatal(err)
+			}
+			contents, err := os.ReadFile(filepath.Join(root, "mods", "info.json"))
+			if err != nil || string(contents) != "{}" {
+				t.Errorf("extracted %q, %v", contents, err)
+			}
+		})
+	}
+}
@@ -2,7 +2,6 @@ package launchers
 
 import (
 	"context"
-	"errors"
 	"io"
 	"log"
 	"os"
@@ -10,6 +9,8 @@ import (
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 )
@@ -18,20 +19,91 @@ type s3downloadJob struct {
 	from, to string
 }
 
+// s3downloadIfChangedJob leaves the file alone when it's as big as the object and no older.
+type s3downloadIfChangedJob struct {
+	from, to string
+	object   types.Object
+}
+
+type s3uploadJob struct {
+	from, to string
+}
+
+// S3API is the part of the S3 client the launcher uses, so tests can put a fake in its place.
+type S3API interface {
+	manager.UploadAPIClient
+	manager.DownloadAPIClient
+	manager.ListObjectsV2APIClient
+	HeadObject(context.Context, *s3.HeadObjectInput, ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+	DeleteObject(context.Context, *s3.DeleteObjectInput, ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
+}
+
+// S3Endpoint is where the state lives when it isn't AWS itself, like MinIO, Ceph or Cloudflare R2. The zero value is
+// AWS with the default credentials.
+type S3Endpoint struct {
+	URL       string
+	PathStyle bool
+	AccessKey string
+	SecretKey string
+}
+
 var ctx = context.Background()
-var cfg = awsLoadConfig()
-var s3client = s3.NewFromConfig(cfg)
+var s3client S3API
+var envBucket string
+var envPrefix string
 
-func awsLoadConfig() aws.Config {
+// setupAws reads where the state lives, and connects to S3 unless UseS3Client was told otherwise.
+func setupAws() error {
+	envBucket = os.Getenv("BUCKET")
+	envPrefix = ensureItsAFolder(os.Getenv("PREFIX"))
+	if s3client != nil {
+		return nil
+	}
 	cfg, err := config.LoadDefaultConfig(ctx)
 	if err != nil {
-		log.Panic(err)
+		return err
+	}
+	s3client = s3.NewFromConfig(cfg, S3EndpointFromEnv().Apply)
+	return nil
+}
+
+func S3EndpointFromEnv() S3Endpoint {
+	return S3Endpoint{
+		URL:       os.Getenv("S3_ENDPOINT"),
+		PathStyle: os.Getenv("S3_PATH_STYLE") == "true",
+		AccessKey: os.Getenv("S3_ACCESS_KEY_ID"),
+		SecretKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+	}
+}
+
+// Apply points an S3 client at the endpoint.
+func (endpoint S3Endpoint) Apply(options *s3.Options) {
+	if endpoint.URL != "" {
+		options.EndpointResolver = s3.EndpointResolverFromURL(endpoint.URL)
+	}
+	options.UsePathStyle = endpoint.PathStyle
+	if endpoint.AccessKey != "" {
+		options.Credentials = credentials.NewStaticCredentialsProvider(endpoint.AccessKey, endpoint.SecretKey, "")
+	}
+}
+
+// AddVariables tells a launcher about the endpoint, through what S3EndpointFromEnv reads.
+func (endpoint S3Endpoint) AddVariables(variables map[string]string) {
+	if endpoint.URL != "" {
+		variables["S3_ENDPOINT"] = endpoint.URL
+	}
+	if endpoint.PathStyle {
+		variables["S3_PATH_STYLE"] = "true"
+	}
+	if endpoint.AccessKey != "" {
+		variables["S3_ACCESS_KEY_ID"] = endpoint.AccessKey
+		variables["S3_SECRET_ACCESS_KEY"] = endpoint.SecretKey
 	}
-	return cfg
 }
 
-var envBucket = os.Getenv("BUCKET")
-var envPrefix = ensureItsAFolder(os.Getenv("PREFIX"))
+func UseS3Client(client S3API) {
+	s3client = client
+}
 
 func ensureItsAFolder(path string) string {
 	if path != "" && !strings.HasSuffix(path, "/") {
@@ -40,67 +112,105 @@ func ensureItsAFolder(path string) string {
 	return path
 }
 
-func s3listRelevantObjects(prefix string) map[string]*string {
+// s3key puts a name under the channel's prefix. Names starting with a slash are relative to the bucket instead, for
+// what the whole guild shares.
+func s3key(name string) string {
+	if strings.HasPrefix(name, "/") {
+		return name[1:]
+	}
+	return envPrefix + name
+}
+
+// s3listRelevantObjects tells the objects under a folder of the channel's prefix, by their name in that folder.
+func s3listRelevantObjects(prefix string) (map[string]types.Object, error) {
 	input := s3.ListObjectsV2Input{
 		Bucket: &envBucket,
-		Prefix: aws.String(ensureItsAFolder(envPrefix + prefix)),
+		Prefix: aws.String(ensureItsAFolder(s3key(prefix))),
 	}
 	pl := len(*input.Prefix)
-	result := map[string]*string{}
+	result := map[string]types.Object{}
 	for {
 		output, err := s3client.ListObjectsV2(ctx, &input)
 		if err != nil {
-			log.Panic(err)
+			return nil, err
 		}
 		for _, value := range output.Contents {
-			result[(*value.Key)[pl:]] = value.Key
+			result[(*value.Key)[pl:]] = value
 		}
 		if output.NextContinuationToken == nil {
-			return result
+			return result, nil
 		}
 		input.ContinuationToken = output.NextContinuationToken
 	}
 }
 
-func s3download(name string) io.ReadCloser {
+// s3download streams an object; nil if it doesn't exist. Big files should go through s3downloadFile instead.
+func s3download(name string) (io.ReadCloser, error) {
 	input := s3.GetObjectInput{
 		Bucket: &envBucket,
-		Key:    aws.String(envPrefix + name),
+		Key:    aws.String(s3key(name)),
 	}
 	output, err := s3client.GetObject(ctx, &input)
+	if isNotFound(err) {
+		return nil, nil
+	}
 	if err != nil {
-		var noSuchKey *types.NoSuchKey
-		if errors.As(err, &noSuchKey) {
-			return nil
-		} else {
-			log.Panic(err)
-		}
+		return nil, err
 	}
-	return output.Body
+	return output.Body, nil
 }
 
 func s3upload(name string, body io.Reader) error {
 	input := s3.PutObjectInput{
 		Bucket: &envBucket,
-		Key:    aws.String(envPrefix + name),
+		Key:    aws.String(s3key(name)),
 		Body:   body,
 	}
-	_, err := s3client.PutObject(ctx, &input)
+	_, err := s3uploader().Upload(ctx, &input)
+	return err
+}
+
+func s3delete(name string) error {
+	input := s3.DeleteObjectInput{
+		Bucket: &envBucket,
+		Key:    aws.String(s3key(name)),
+	}
+	_, err := s3client.DeleteObject(ctx, &input)
 	return err
 }
 
+// s3downloadString reads a small object whole; empty if it doesn't exist.
+func s3downloadString(name string) (string, error) {
+	reader, err := s3download(name)
+	if reader == nil || err != nil {
+		return "", err
+	}
+	defer CloseDontCare(reader)
+	buffer, err := io.ReadAll(reader)
+	return strings.TrimSpace(string(buffer)), err
+}
+
 func (job s3downloadJob) Run() error {
-	reader := s3download(job.from)
-	if reader == nil {
-		return nil // Not found (???)
+	found, err := s3downloadFile(job.from, job.to)
+	if !found {
+		log.Printf("%s vanished before we could download it", job.from)
 	}
+	return err
+}
 
-	file, err := os.Create(job.to)
-	if err != nil {
+func (job s3downloadIfChangedJob) Run() error {
+	modified := aws.ToTime(job.object.LastModified)
+	if info, err := os.Stat(job.to); err == nil && info.Size() == job.object.Size && !info.ModTime().Before(modified) {
+		return nil
+	}
+	found, err := s3downloadFile(job.from, job.to)
+	if !found || err != nil || modified.IsZero() {
 		return err
 	}
-	defer CloseDontCare(file)
+	// So the next session can tell it's the same
+	return os.Chtimes(job.to, modified, modified)
+}
 
-	_, err = io.Copy(file, reader)
-	return err
+func (job s3uploadJob) Run() error {
+	return s3uploadFile(job.from, job.to)
 }
@@ -0,0 +1,64 @@
+package launchers
+
+import (
+	"bytes"
+	"narval/fakes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestStateDownloadsOnlyWhatChanged(t *testing.T) {
+	fake := fakes.NewS3()
+	UseS3Client(fake)
+	t.Cleanup(func() { s3client = nil })
+	envBucket, envPrefix = "bucket", "channel/"
+	path := filepath.Join(t.TempDir(), "server-settings.json")
+	key := "channel/state/server-settings.json"
+	put := func(content string) {
+		_, err := fake.PutObject(ctx, &s3.PutObjectInput{Bucket: &envBucket, Key: &key, Body: bytes.NewReader([]byte(content))})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	fetch := func() string {
+		objects, err := s3listRelevantObjects("state")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = (s3downloadIfChangedJob{"state/server-settings.json", path, objects["server-settings.json"]}).Run(); err != nil {
+			t.Fatal(err)
+		}
+		buffer, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(buffer)
+	}
+
+	put(`{"name": "a"}`)
+	if fetch() != `{"name": "a"}` {
+		t.Fatal("didn't download the state")
+	}
+	// Left over from the last session, and nobody changed it since
+	if err := os.WriteFile(path, []byte(`{"name": "b"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, time.Now().Add(time.Hour), time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if fetch() != `{"name": "b"}` {
+		t.Error("downloaded a file that didn't change")
+	}
+	// Changed from Discord since
+	if err := os.Chtimes(path, time.Now().Add(-time.Hour), time.Now().Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	put(`{"name": "c"}`)
+	if fetch() != `{"name": "c"}` {
+		t.Error("kept a file that changed")
+	}
+}
@@ -0,0 +1,87 @@
+package launchers
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// factorioChecksumsUrl is a variable so tests can stand in for factorio.com.
+var factorioChecksumsUrl = "https://factorio.com/download/sha256sums/"
+
+// hashingCopy copies everything into a new file at path and tells the SHA-256 of what it wrote.
+func hashingCopy(path string, reader io.Reader) (string, int64, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer CloseDontCare(file)
+	hash := sha256.New()
+	written, err := io.Copy(io.MultiWriter(file, hash), reader)
+	if err != nil {
+		return "", written, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), written, file.Sync()
+}
+
+// downloadVerified fetches url into path, making sure the server meant it and that we got all of it.
+func downloadVerified(url, path string) (string, error) {
+	response, err := http.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer CloseDontCare(response.Body)
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s answered %s", url, response.Status)
+	}
+	hash, written, err := hashingCopy(path, response.Body)
+	if err != nil {
+		return "", err
+	}
+	if response.ContentLength >= 0 && written != response.ContentLength {
+		return "", fmt.Errorf("only got %d bytes of %d from %s", written, response.ContentLength, url)
+	}
+	return hash, nil
+}
+
+// publishedFactorioChecksum looks up the SHA-256 Factorio publishes for the headless archive of a version.
+func publishedFactorioChecksum(version Version) (string, error) {
+	httpClient := http.Client{Timeout: 10 * time.Second}
+	response, err := httpClient.Get(factorioChecksumsUrl)
+	if err != nil {
+		return "", err
+	}
+	defer CloseDontCare(response.Body)
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s answered %s", factorioChecksumsUrl, response.Status)
+	}
+	return findChecksum(response.Body, "headless", "_"+version.String()+".tar.xz")
+}
+
+// findChecksum reads a sha256sum listing and returns the hash of the first file whose name has all the given parts.
+func findChecksum(listing io.Reader, nameParts ...string) (string, error) {
+	scanner := bufio.NewScanner(listing)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		matches := true
+		for _, part := range nameParts {
+			matches = matches && strings.Contains(fields[1], part)
+		}
+		if matches {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("no published checksum for %s", strings.Join(nameParts, "*"))
+}
@@ -0,0 +1,168 @@
+package launchers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"narval/fakes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const testArchive = "not really a tar.xz"
+
+func testSha256(content string) string {
+	hash := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(hash[:])
+}
+
+// useTestFactorioCom stands in for the downloads and the published checksums of factorio.com.
+func useTestFactorioCom(t *testing.T, checksums string) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		switch {
+		case request.URL.Path == "/sha256sums/":
+			_, _ = writer.Write([]byte(checksums))
+		case strings.HasPrefix(request.URL.Path, "/get-download/1.1.110/"):
+			_, _ = writer.Write([]byte(testArchive))
+		case request.URL.Path == "/short":
+			writer.Header().Set("Content-Length", "100")
+			_, _ = writer.Write([]byte(testArchive))
+		default:
+			http.NotFound(writer, request)
+		}
+	}))
+	t.Cleanup(server.Close)
+	previousChecksums, previousDownload := factorioChecksumsUrl, factorioDownloadUrl
+	factorioChecksumsUrl, factorioDownloadUrl = server.URL+"/sha256sums/", server.URL+"/get-download/%v/headless/linux64"
+	t.Cleanup(func() { factorioChecksumsUrl, factorioDownloadUrl = previousChecksums, previousDownload })
+}
+
+// useTestArchivePaths keeps the archives the test downloads to itself.
+func useTestArchivePaths(t *testing.T) {
+	folder := t.TempDir()
+	previousXz, previousZstd := factorioArchivePath, factorioZstdArchivePath
+	factorioArchivePath = filepath.Join(folder, "game.tar.xz")
+	factorioZstdArchivePath = filepath.Join(folder, "game.tar.zst")
+	t.Cleanup(func() { factorioArchivePath, factorioZstdArchivePath = previousXz, previousZstd })
+}
+
+func useTestS3(t *testing.T) *fakes.S3 {
+	fake := fakes.NewS3()
+	UseS3Client(fake)
+	t.Cleanup(func() { s3client = nil })
+	envBucket, envPrefix = "bucket", "channel/"
+	return fake
+}
+
+func TestDownloadVerified(t *testing.T) {
+	useTestFactorioCom(t, "")
+	path := filepath.Join(t.TempDir(), "download")
+	hash, err := downloadVerified(strings.Replace(factorioDownloadUrl, "%v", "1.1.110", 1), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != testSha256(testArchive) {
+		t.Errorf("hash is %s", hash)
+	}
+	if _, err = downloadVerified(strings.Replace(factorioDownloadUrl, "%v", "0.0.0", 1), path); err == nil {
+		t.Error("a 404 counted as a download")
+	}
+	if _, err = downloadVerified(strings.TrimSuffix(factorioChecksumsUrl, "sha256sums/")+"short", path); err == nil {
+		t.Error("a download cut short counted as complete")
+	}
+}
+
+func TestPublishedFactorioChecksum(t *testing.T) {
+	useTestFactorioCom(t, fmt.Sprintf("%s  factorio_linux_1.1.109.tar.xz\n%s  factorio_headless_x64_1.1.110.tar.xz\n",
+		testSha256("other"), strings.ToUpper(testSha256(testArchive))))
+	checksum, err := publishedFactorioChecksum(Version{1, 1, 110})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checksum != testSha256(testArchive) {
+		t.Errorf("checksum is %s", checksum)
+	}
+	if _, err = publishedFactorioChecksum(Version{1, 1, 109}); err == nil {
+		t.Error("found a headless checksum that isn't published")
+	}
+
+	factorioChecksumsUrl += "missing/"
+	if _, err = publishedFactorioChecksum(Version{1, 1, 110}); err == nil {
+		t.Error("a 404 gave a checksum")
+	}
+}
+
+func TestDownloadedArchiveMustMatchThePublishedChecksum(t *testing.T) {
+	useTestS3(t)
+	useTestArchivePaths(t)
+	useTestFactorioCom(t, testSha256("the real thing")+"  factorio_headless_x64_1.1.110.tar.xz\n")
+	server := &FactorioServer{version: Version{1, 1, 110}}
+	if _, _, err := server.prepareGetGameArchive(); err == nil || !strings.Contains(err.Error(), "expected") {
+		t.Errorf("got %v for an archive that doesn't match", err)
+	}
+
+	useTestFactorioCom(t, testSha256(testArchive)+"  factorio_headless_x64_1.1.110.tar.xz\n")
+	path, cache, err := server.prepareGetGameArchive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != factorioArchivePath || cache == nil {
+		t.Errorf("got %s, expected a fresh %s to cache", path, factorioArchivePath)
+	}
+}
+
+func TestCachedArchiveVerification(t *testing.T) {
+	fake := useTestS3(t)
+	useTestArchivePaths(t)
+	put := func(key, content string) {
+		_, err := fake.PutObject(ctx, &s3.PutObjectInput{Bucket: &envBucket, Key: &key,
+			Body: bytes.NewReader([]byte(content))})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	server := &FactorioServer{version: Version{1, 1, 110}}
+	key := "channel/game/1.1.110.tar.xz"
+	for _, test := range []struct {
+		name, recorded, expected string
+		accepted                 bool
+	}{
+		{"matches the published checksum", "", testSha256(testArchive), true},
+		{"matches the recorded checksum", testSha256(testArchive), "", true},
+		{"nothing to verify against", "", "", true},
+		{"differs from the published checksum", testSha256(testArchive), testSha256("other"), false},
+		{"differs from the recorded checksum", testSha256("other"), "", false},
+	} {
+		put(key, testArchive)
+		if test.recorded != "" {
+			put(key+".sha256", test.recorded+"\n")
+		}
+		accepted := server.prepareGetGameCached("game/1.1.110.tar.xz", factorioArchivePath, test.expected)
+		if accepted != test.accepted {
+			t.Errorf("%s: accepted is %v", test.name, accepted)
+		}
+		_, archiveKept := fake.Object("bucket", key)
+		_, checksumKept := fake.Object("bucket", key+".sha256")
+		if !test.accepted && (archiveKept || checksumKept) {
+			t.Errorf("%s: the cache wasn't invalidated", test.name)
+		}
+		if test.accepted && !archiveKept {
+			t.Errorf("%s: the cache was thrown away", test.name)
+		}
+		for _, name := range []string{key, key + ".sha256"} {
+			_, _ = fake.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: &envBucket, Key: &name})
+		}
+		_ = os.Remove(factorioArchivePath)
+	}
+
+	if server.prepareGetGameCached("game/1.1.110.tar.xz", factorioArchivePath, testSha256(testArchive)) {
+		t.Error("used a cache that isn't there")
+	}
+}
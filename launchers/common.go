@@ -1,20 +1,23 @@
 package launchers
 
 import (
-	"archive/tar"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"os"
-	"path/filepath"
+	"os/exec"
+	"syscall"
 )
 
 type Server interface {
 	Prepare() error
 	Start() error
-	NumPlayers() int
+	Wait() error
+	LogFiles() []string
 	GetLinesChannel() chan ParsedLine
 	SendCommand(ParsedLine) error
+	// Interrupt saves and stops the server right away, whoever is playing, telling them why.
+	Interrupt(reason string)
 }
 
 type ParsedLine struct {
@@ -22,6 +25,7 @@ type ParsedLine struct {
 	Event   EventKind
 	Author  User
 	Message string
+	Players int // after someone joined or left, how many are left playing
 }
 
 type EventKind byte
@@ -33,11 +37,12 @@ const (
 	EventStop
 	EventJoin
 	EventLeave
+	EventSave
 )
 
 type User string
 
-func stdinPassThrough(destination io.WriteCloser) {
+func stdinPassThrough(destination io.Writer) {
 	buffer := []byte{1}
 	numBytes, _ := os.Stdin.Read(buffer)
 	for numBytes > 0 {
@@ -47,52 +52,27 @@ func stdinPassThrough(destination io.WriteCloser) {
 	_ = os.Stdin.Close()
 }
 
-func unTar(decompressedReader io.Reader, pathPrefix string) error {
-	madeDir := map[string]bool{}
-	unpacked := tar.NewReader(decompressedReader)
-	header, err := unpacked.Next()
-	for ; err == nil; header, err = unpacked.Next() {
-		if header == nil { // no one knows why this happens
-			continue
-		}
-		relativePath := filepath.FromSlash(header.Name)
-		path := filepath.Join(pathPrefix, relativePath)
-		info := header.FileInfo()
-		mode := info.Mode()
-		switch {
-		case mode.IsRegular():
-			log.Printf("Extracting %s", path)
-			dir := filepath.Dir(path)
-			if !madeDir[dir] {
-				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
-					return err
-				}
-				madeDir[dir] = true
-			}
-			writeFile, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, mode.Perm())
-			if err != nil {
-				return err
-			}
-			numBytesWritten, err := io.Copy(writeFile, unpacked)
-			if closeErr := writeFile.Close(); closeErr != nil && err == nil {
-				err = closeErr
-			}
-			if err != nil {
-				return fmt.Errorf("error writing to %s: %v", path, err)
-			}
-			if numBytesWritten != header.Size {
-				return fmt.Errorf("only wrote %d bytes to %s; expected %d", numBytesWritten, path, header.Size)
-			}
-		case mode.IsDir():
-			_ = os.MkdirAll(path, 0755)
-			madeDir[path] = true
-		}
+func describeExit(err error) string {
+	if err == nil {
+		return "exited normally"
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return err.Error()
+	}
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return fmt.Sprintf("killed by signal %v", status.Signal())
 	}
-	if err == io.EOF {
-		log.Print("Done!")
-		return nil
+	return fmt.Sprintf("exit code %d", exitErr.ExitCode())
+}
+
+func containsString(haystack []string, needle string) bool {
+	for _, value := range haystack {
+		if value == needle {
+			return true
+		}
 	}
-	return err
+	return false
 }
 
 func CloseDontCare(closer io.Closer) {
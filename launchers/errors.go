@@ -3,3 +3,6 @@ package launchers
 import "errors"
 
 var errInvalidCommand = errors.New("invalid command")
+var errUnsafePath = errors.New("archive member escapes its folder")
+var errArchiveTooBig = errors.New("archive too big")
+var errUnknownArchive = errors.New("not an archive we know")
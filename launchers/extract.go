@@ -0,0 +1,290 @@
+package launchers
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// extractLimits bounds what a single archive may unpack to, since mods and saves come from users.
+type extractLimits struct {
+	maxBytes int64
+	maxFiles int
+}
+
+// extraction keeps track of one archive being unpacked under root.
+type extraction struct {
+	root     string
+	limits   extractLimits
+	bytes    int64
+	files    int
+	madeDir  map[string]bool
+	dirTimes map[string]time.Time
+	symlinks []string
+}
+
+var defaultExtractLimits = extractLimits{maxBytes: 8 << 30, maxFiles: 100000}
+
+func unTar(decompressedReader io.Reader, pathPrefix string) error {
+	return unTarLimited(decompressedReader, pathPrefix, defaultExtractLimits)
+}
+
+// unTarLimited unpacks a tar stream under pathPrefix, refusing anything that would end up outside of it, either by
+// its name or through a link, and anything beyond the limits.
+func unTarLimited(decompressedReader io.Reader, pathPrefix string, limits extractLimits) error {
+	job, err := newExtraction(pathPrefix, limits)
+	if err != nil {
+		return err
+	}
+	unpacked := tar.NewReader(decompressedReader)
+	header, err := unpacked.Next()
+	for ; err == nil; header, err = unpacked.Next() {
+		if header == nil { // no one knows why this happens
+			continue
+		}
+		if err = job.extractTarEntry(header, unpacked); err != nil {
+			break
+		}
+	}
+	// Even a failed extraction must not leave a way out behind
+	finishErr := job.finish()
+	if err != io.EOF {
+		return err
+	}
+	if finishErr != nil {
+		return finishErr
+	}
+	log.Print("Done!")
+	return nil
+}
+
+func newExtraction(pathPrefix string, limits extractLimits) (*extraction, error) {
+	if err := os.MkdirAll(pathPrefix, 0755); err != nil {
+		return nil, err
+	}
+	root, err := filepath.Abs(pathPrefix)
+	if err != nil {
+		return nil, err
+	}
+	root, err = filepath.EvalSymlinks(root)
+	if err != nil {
+		return nil, err
+	}
+	return &extraction{
+		root:     root,
+		limits:   limits,
+		madeDir:  map[string]bool{root: true},
+		dirTimes: map[string]time.Time{},
+	}, nil
+}
+
+func (job *extraction) extractTarEntry(header *tar.Header, contents io.Reader) error {
+	path, err := job.safePath(header.Name)
+	if err != nil {
+		return err
+	}
+	if path == job.root {
+		return nil // "./" and friends
+	}
+
+	switch header.Typeflag {
+	case tar.TypeReg, tar.TypeRegA:
+		log.Printf("Extracting %s", path)
+		return job.writeFile(path, header.FileInfo().Mode().Perm(), header.Size, header.ModTime, contents)
+	case tar.TypeDir:
+		if err = job.makeDir(path); err != nil {
+			return err
+		}
+		job.dirTimes[path] = header.ModTime
+		return nil
+	case tar.TypeSymlink:
+		return job.makeSymlink(path, header.Linkname)
+	case tar.TypeLink:
+		return job.makeHardlink(path, header.Linkname)
+	}
+	return nil // Devices, fifos and the like have no business in our archives
+}
+
+// safePath maps an archive member name to where it goes, or fails if that would be outside the root.
+func (job *extraction) safePath(name string) (string, error) {
+	name = filepath.FromSlash(name)
+	if filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return "", fmt.Errorf("%w: %s", errUnsafePath, name)
+	}
+	path := filepath.Join(job.root, name)
+	if !job.contains(path) {
+		return "", fmt.Errorf("%w: %s", errUnsafePath, name)
+	}
+	return path, nil
+}
+
+func (job *extraction) contains(path string) bool {
+	return path == job.root || strings.HasPrefix(path, job.root+string(filepath.Separator))
+}
+
+// checkParent makes sure the folder path goes into really is inside the root, even after following links created by
+// earlier members.
+func (job *extraction) checkParent(path string) error {
+	dir := filepath.Dir(path)
+	if err := job.makeDir(dir); err != nil {
+		return err
+	}
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	if !job.contains(resolved) {
+		return fmt.Errorf("%w: %s goes through a link", errUnsafePath, path)
+	}
+	return nil
+}
+
+func (job *extraction) makeDir(path string) error {
+	if job.madeDir[path] {
+		return nil
+	}
+	if err := job.checkParent(path); err != nil {
+		return err
+	}
+	info, err := os.Lstat(path)
+	if err == nil && info.Mode()&os.ModeSymlink != 0 {
+		info, err = os.Stat(path) // Where it leads is checked when something is put in it
+	}
+	if err == nil && !info.IsDir() {
+		return fmt.Errorf("%w: %s is not a folder", errUnsafePath, path)
+	}
+	if err = os.Mkdir(path, 0755); err != nil && !os.IsExist(err) {
+		return err
+	}
+	job.madeDir[path] = true
+	return nil
+}
+
+// replace clears whatever is at path so nothing gets written through an existing link.
+func (job *extraction) replace(path string) error {
+	if err := job.checkParent(path); err != nil {
+		return err
+	}
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%w: %s is a folder", errUnsafePath, path)
+	}
+	return os.Remove(path)
+}
+
+func (job *extraction) countFile(size int64) error {
+	job.files++
+	if job.files > job.limits.maxFiles {
+		return fmt.Errorf("%w: more than %d files", errArchiveTooBig, job.limits.maxFiles)
+	}
+	if size < 0 || job.bytes+size > job.limits.maxBytes {
+		return fmt.Errorf("%w: more than %d bytes", errArchiveTooBig, job.limits.maxBytes)
+	}
+	return nil
+}
+
+func (job *extraction) writeFile(path string, mode os.FileMode, size int64, modTime time.Time, contents io.Reader) error {
+	if err := job.countFile(size); err != nil {
+		return err
+	}
+	if err := job.replace(path); err != nil {
+		return err
+	}
+	writeFile, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+	// Never trust the size in the header more than the limits
+	numBytesWritten, err := io.Copy(writeFile, io.LimitReader(contents, job.limits.maxBytes-job.bytes+1))
+	if closeErr := writeFile.Close(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	job.bytes += numBytesWritten
+	if err != nil {
+		return fmt.Errorf("error writing to %s: %v", path, err)
+	}
+	if job.bytes > job.limits.maxBytes {
+		return fmt.Errorf("%w: more than %d bytes", errArchiveTooBig, job.limits.maxBytes)
+	}
+	if size >= 0 && numBytesWritten != size {
+		return fmt.Errorf("only wrote %d bytes to %s; expected %d", numBytesWritten, path, size)
+	}
+	if !modTime.IsZero() {
+		return os.Chtimes(path, modTime, modTime)
+	}
+	return nil
+}
+
+func (job *extraction) makeSymlink(path, target string) error {
+	if err := job.countFile(0); err != nil {
+		return err
+	}
+	target = filepath.FromSlash(target)
+	if filepath.IsAbs(target) || !job.contains(filepath.Join(filepath.Dir(path), target)) {
+		return fmt.Errorf("%w: %s links to %s", errUnsafePath, path, target)
+	}
+	if err := job.replace(path); err != nil {
+		return err
+	}
+	job.symlinks = append(job.symlinks, path)
+	return os.Symlink(target, path)
+}
+
+func (job *extraction) makeHardlink(path, target string) error {
+	if err := job.countFile(0); err != nil {
+		return err
+	}
+	targetPath, err := job.safePath(target)
+	if err != nil {
+		return err
+	}
+	if err = job.checkParent(targetPath); err != nil {
+		return err
+	}
+	info, err := os.Lstat(targetPath)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%w: %s links to %s which is not a file", errUnsafePath, path, target)
+	}
+	if err = job.replace(path); err != nil {
+		return err
+	}
+	return os.Link(targetPath, path)
+}
+
+// finish checks that links created along the way didn't end up pointing outside after all, and sets the folders'
+// times now that nothing else will be written into them.
+func (job *extraction) finish() error {
+	var err error
+	for _, path := range job.symlinks {
+		resolved, evalErr := filepath.EvalSymlinks(path)
+		if os.IsNotExist(evalErr) {
+			continue // Dangling links can't hurt anyone
+		}
+		if evalErr != nil || !job.contains(resolved) {
+			_ = os.Remove(path)
+			if err == nil {
+				err = fmt.Errorf("%w: %s leads outside", errUnsafePath, path)
+			}
+		}
+	}
+	for path, modTime := range job.dirTimes {
+		if info, statErr := os.Lstat(path); statErr == nil && info.IsDir() && !modTime.IsZero() {
+			_ = os.Chtimes(path, modTime, modTime)
+		}
+	}
+	return err
+}
@@ -0,0 +1,166 @@
+package launchers
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type tarMember struct {
+	name     string
+	typeflag byte
+	body     string
+	linkname string
+}
+
+func makeTar(t testing.TB, members ...tarMember) []byte {
+	var buffer bytes.Buffer
+	writer := tar.NewWriter(&buffer)
+	for _, member := range members {
+		header := tar.Header{
+			Name:     member.name,
+			Typeflag: member.typeflag,
+			Linkname: member.linkname,
+			Mode:     0644,
+			Size:     int64(len(member.body)),
+			ModTime:  time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC),
+		}
+		if member.typeflag != tar.TypeReg {
+			header.Size = 0
+		}
+		if member.typeflag == tar.TypeDir {
+			header.Mode = 0755
+		}
+		if err := writer.WriteHeader(&header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := writer.Write([]byte(member.body)); err != nil && header.Size > 0 {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func TestUnTarExtractsFilesLinksAndTimes(t *testing.T) {
+	root := t.TempDir()
+	archive := makeTar(t,
+		tarMember{name: "factorio/", typeflag: tar.TypeDir},
+		tarMember{name: "factorio/bin/x64/factorio", typeflag: tar.TypeReg, body: "#!/bin/sh\n"},
+		tarMember{name: "factorio/data/info.json", typeflag: tar.TypeReg, body: "{}"},
+		tarMember{name: "factorio/data/current", typeflag: tar.TypeSymlink, linkname: "info.json"},
+		tarMember{name: "factorio/data/copy.json", typeflag: tar.TypeLink, linkname: "factorio/data/info.json"},
+	)
+	if err := unTar(bytes.NewReader(archive), root); err != nil {
+		t.Fatal(err)
+	}
+
+	contents, err := os.ReadFile(filepath.Join(root, "factorio/data/current"))
+	if err != nil || string(contents) != "{}" {
+		t.Errorf("symlink reads %q, %v", contents, err)
+	}
+	contents, err = os.ReadFile(filepath.Join(root, "factorio/data/copy.json"))
+	if err != nil || string(contents) != "{}" {
+		t.Errorf("hardlink reads %q, %v", contents, err)
+	}
+	info, err := os.Stat(filepath.Join(root, "factorio/bin/x64/factorio"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC); !info.ModTime().Equal(want) {
+		t.Errorf("mtime is %v, want %v", info.ModTime(), want)
+	}
+}
+
+func TestUnTarRejectsEscapes(t *testing.T) {
+	cases := map[string][]tarMember{
+		"dot dot":          {{name: "../evil", typeflag: tar.TypeReg, body: "x"}},
+		"nested dot dot":   {{name: "mods/../../evil", typeflag: tar.TypeReg, body: "x"}},
+		"absolute":         {{name: "/tmp/evil", typeflag: tar.TypeReg, body: "x"}},
+		"absolute symlink": {{name: "link", typeflag: tar.TypeSymlink, linkname: "/etc"}},
+		"relative symlink": {{name: "link", typeflag: tar.TypeSymlink, linkname: "../.."}},
+		"write through symlink": {
+			{name: "here", typeflag: tar.TypeSymlink, linkname: "."},
+			{name: "up", typeflag: tar.TypeSymlink, linkname: "here/.."},
+			{name: "up/evil", typeflag: tar.TypeReg, body: "x"},
+		},
+		"hardlink outside": {{name: "link", typeflag: tar.TypeLink, linkname: "../outside"}},
+	}
+	for name, members := range cases {
+		t.Run(name, func(t *testing.T) {
+			parent := t.TempDir()
+			root := filepath.Join(parent, "game")
+			if err := os.WriteFile(filepath.Join(parent, "outside"), []byte("secret"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			err := unTar(bytes.NewReader(makeTar(t, members...)), root)
+			if !errors.Is(err, errUnsafePath) {
+				t.Errorf("got %v, want %v", err, errUnsafePath)
+			}
+			if _, err := os.Stat(filepath.Join(parent, "evil")); err == nil {
+				t.Error("wrote outside the root")
+			}
+		})
+	}
+}
+
+func TestUnTarEnforcesLimits(t *testing.T) {
+	archive := makeTar(t,
+		tarMember{name: "a", typeflag: tar.TypeReg, body: "12345"},
+		tarMember{name: "b", typeflag: tar.TypeReg, body: "67890"},
+	)
+	err := unTarLimited(bytes.NewReader(archive), t.TempDir(), extractLimits{maxBytes: 8, maxFiles: 10})
+	if !errors.Is(err, errArchiveTooBig) {
+		t.Errorf("size: got %v, want %v", err, errArchiveTooBig)
+	}
+	err = unTarLimited(bytes.NewReader(archive), t.TempDir(), extractLimits{maxBytes: 100, maxFiles: 1})
+	if !errors.Is(err, errArchiveTooBig) {
+		t.Errorf("count: got %v, want %v", err, errArchiveTooBig)
+	}
+}
+
+func FuzzUnTar(f *testing.F) {
+	f.Add(makeTar(f, tarMember{name: "dir/file", typeflag: tar.TypeReg, body: "hello"}))
+	f.Add(makeTar(f,
+		tarMember{name: "dir/", typeflag: tar.TypeDir},
+		tarMember{name: "dir/link", typeflag: tar.TypeSymlink, linkname: ".."},
+		tarMember{name: "dir/link/file", typeflag: tar.TypeReg, body: "x"},
+	))
+	f.Add(makeTar(f, tarMember{name: "../escape", typeflag: tar.TypeReg, body: "x"}))
+	f.Fuzz(func(t *testing.T, archive []byte) {
+		parent := t.TempDir()
+		root := filepath.Join(parent, "root")
+		_ = unTarLimited(bytes.NewReader(archive), root, extractLimits{maxBytes: 1 << 20, maxFiles: 100})
+
+		entries, err := os.ReadDir(parent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 || entries[0].Name() != "root" {
+			t.Fatalf("something was created next to the root: %v", entries)
+		}
+		err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.Mode()&os.ModeSymlink == 0 {
+				return err
+			}
+			resolved, err := filepath.EvalSymlinks(path)
+			if err != nil {
+				return nil // dangling
+			}
+			realRoot, _ := filepath.EvalSymlinks(root)
+			if rel, err := filepath.Rel(realRoot, resolved); err != nil || rel == ".." || len(rel) > 2 && rel[:3] == "../" {
+				t.Errorf("%s leads outside to %s", path, resolved)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+}
@@ -2,30 +2,55 @@ package launchers
 
 import (
 	"bufio"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
-	"net/http"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"regexp"
 	"strings"
 	"time"
-
-	"github.com/ulikunitz/xz"
 )
 
 type FactorioServer struct {
-	players       map[User]bool
-	shutdownAt    time.Time
-	maxSession    time.Time
-	shutdownGrace time.Duration
-	out           chan ParsedLine
-	in            io.WriteCloser
+	players map[User]bool
+	policy  *ShutdownPolicy
+	out     chan ParsedLine
+	in      io.WriteCloser
+	command *exec.Cmd
+	saved   chan error
+	version Version
+}
+
+// factorioStdin forwards our stdin to whichever game process is currently running, so it survives restarts.
+type factorioStdin struct {
+	server *FactorioServer
 }
 
 const factorioBinaryPath = "game/factorio/bin/x64/factorio"
+const factorioWriteDataPath = "game/factorio"
+const factorioInitialSavePath = "game/save.zip"
+const factorioBaseInfoPath = "game/factorio/data/base/info.json"
+const factorioModsPath = "game/mods"
+const factorioUploadedModsPrefix = "uploads/mods/"
+const factorioMapGenSettingsPath = "game/map-gen-settings.json"
+const factorioMapSettingsPath = "game/map-settings.json"
+const factorioServerSettingsPath = "game/server-settings.json"
+const factorioServerAdminListPath = "game/server-adminlist.json"
+const factorioServerWhitelistPath = "game/server-whitelist.json"
+
+// factorioRunServerSettingsPath is the server settings with the credentials added, kept out of the state.
+const factorioRunServerSettingsPath = "game/factorio/server-settings.json"
+
+// factorioDownloadUrl is where the headless archive of a version is, a variable so tests can stand in for factorio.com.
+var factorioDownloadUrl = "https://factorio.com/get-download/%v/headless/linux64"
+
+// The archives go in the temporary folder, which launchers sharing a host each get their own of
+var factorioArchivePath = filepath.Join(os.TempDir(), "game.tar.xz")
+var factorioZstdArchivePath = filepath.Join(os.TempDir(), "game.tar.zst")
 
 var factorioRegexpInGame = regexp.MustCompile(`^ *\d+\.\d{3} Info ServerMultiplayerManager\.cpp:.+ changing state .+ to\(InGame\)$`)
 var factorioRegexpSaved = regexp.MustCompile(`^ *\d+\.\d{3} Info AppManagerStates\.cpp:\d+: Saving finished$`)
@@ -37,139 +62,484 @@ func (server *FactorioServer) Prepare() error {
 	worker := ParallelWorker{}
 	worker.Add(server.prepareGetGame)
 	worker.Add(server.prepareGetState)
-	return worker.Join()
+	err := worker.Join()
+	if err != nil {
+		return err
+	}
+	err = server.prepareGetUploads()
+	if err != nil {
+		return err
+	}
+	err = prepareMods(server.version, factorioModsPath)
+	if err != nil {
+		return err
+	}
+	err = server.checkSaveVersion()
+	if err != nil {
+		return err
+	}
+	err = prepareServerSettings()
+	if err != nil {
+		return err
+	}
+	return server.prepareCreateMap()
 }
 
 func (server *FactorioServer) prepareGetGame() error {
 	var err error
 
-	if _, err = os.Stat(factorioBinaryPath); !errors.Is(err, os.ErrNotExist) {
-		return nil // Already have the game
+	server.version, err = ResolveFactorioVersion(os.Getenv("FACTORIO_VERSION"))
+	if err != nil {
+		return err
 	}
+	log.Printf("Factorio version is %v", server.version)
 
-	reader := s3download("game.tar.xz")
-	if reader == nil {
-		err := server.prepareGetGameDownload()
-		if err != nil {
-			return err
+	if installed, err := installedFactorioVersion(); err == nil {
+		if installed == server.version {
+			return nil // Already have the game
 		}
-		reader, err = os.Open("/tmp/game.tar.xz")
-		if err != nil {
-			return err
+		log.Printf("Replacing installed Factorio %v", installed)
+		for _, folder := range []string{"bin", "data"} {
+			if err = os.RemoveAll(filepath.Join(factorioWriteDataPath, folder)); err != nil {
+				return err
+			}
 		}
-		defer func() { go server.prepareGetGameUpload() }()
 	}
 
-	// Un-xz (why the hell do they use xz!)
-	decompressed, err := xz.NewReader(reader)
+	archivePath, cache, err := server.prepareGetGameArchive()
 	if err != nil {
 		return err
 	}
-
-	// Un-tar
-	err = unTar(decompressed, "game")
+	err = extractArchive(archivePath, "game")
+	if cache != nil {
+		// Only now, since caching ends with removing the archive
+		go cache()
+	}
 	return err
 }
 
-func (*FactorioServer) prepareGetGameDownload() error {
-	version := os.Getenv("FACTORIO_VERSION")
-	if version == "" {
-		version = "latest"
+// prepareGetGameArchive leaves a verified archive of the game in the temporary folder and tells where, and what to do
+// to cache it once it's extracted. Our own zstd repack is preferred since it decompresses much faster than the xz from
+// factorio.com, which comes next, either from our cache if it is good, or from factorio.com itself.
+func (server *FactorioServer) prepareGetGameArchive() (string, func(), error) {
+	base := fmt.Sprintf("game/%v", server.version)
+	if server.prepareGetGameCached(base+".tar.zst", factorioZstdArchivePath, "") {
+		return factorioZstdArchivePath, nil, nil
 	}
-	requestUrl := fmt.Sprintf("https://factorio.com/get-download/%s/headless/linux64", version)
-	log.Printf("Downloading: %s", requestUrl)
-	httpResponse, err := http.Get(requestUrl)
+
+	expected, err := publishedFactorioChecksum(server.version)
 	if err != nil {
-		return err
+		log.Printf("Unable to get the published checksum: %v", err)
+	}
+
+	if server.prepareGetGameCached(base+".tar.xz", factorioArchivePath, expected) {
+		return factorioArchivePath, func() { server.prepareGetGameRepack(base + ".tar.zst") }, nil
 	}
-	defer CloseDontCare(httpResponse.Body)
 
-	file, err := os.Create("/tmp/game.tar.xz")
+	hash, err := server.prepareGetGameDownload()
 	if err != nil {
-		return err
+		return "", nil, err
+	}
+	if expected != "" && hash != expected {
+		return "", nil, fmt.Errorf("downloaded Factorio %v has SHA-256 %s, expected %s", server.version, hash, expected)
 	}
-	defer CloseDontCare(file)
+	return factorioArchivePath, func() {
+		server.prepareGetGameUpload(factorioArchivePath, base+".tar.xz")
+		server.prepareGetGameRepack(base + ".tar.zst")
+	}, nil
+}
 
-	_, err = io.Copy(file, httpResponse.Body)
-	return err
+// prepareGetGameCached fetches a cached archive and checks it against the published checksum, or failing that the
+// one we recorded when caching it. A cached archive that doesn't match is thrown away.
+func (*FactorioServer) prepareGetGameCached(key, path, expected string) bool {
+	found, err := s3downloadFile(key, path)
+	if !found {
+		return false
+	}
+	var hash string
+	if err == nil {
+		hash, err = FileSha256(path)
+	}
+	if err != nil {
+		log.Printf("Unable to fetch cached %s: %v", key, err)
+		return false
+	}
+
+	if expected == "" {
+		expected, err = s3downloadString(key + ".sha256")
+		if err != nil {
+			log.Printf("Unable to fetch recorded checksum of %s: %v", key, err)
+		}
+	}
+	if expected == "" {
+		log.Printf("Nothing to verify cached %s against; trusting it", key)
+		return true
+	}
+	if hash == expected {
+		return true
+	}
+
+	log.Printf("Cached %s has SHA-256 %s, expected %s; invalidating it", key, hash, expected)
+	for _, name := range []string{key, key + ".sha256"} {
+		if err = s3delete(name); err != nil {
+			log.Print(err)
+		}
+	}
+	return false
 }
 
-func (*FactorioServer) prepareGetGameUpload() {
-	fileRead, err := os.Open("/tmp/game.tar.xz")
+func (server *FactorioServer) prepareGetGameDownload() (string, error) {
+	requestUrl := fmt.Sprintf(factorioDownloadUrl, server.version)
+	log.Printf("Downloading: %s", requestUrl)
+	return downloadVerified(requestUrl, factorioArchivePath)
+}
+
+func (*FactorioServer) prepareGetGameUpload(path, key string) {
+	hash, err := FileSha256(path)
+	if err == nil {
+		err = s3uploadFile(path, key)
+	}
 	if err != nil {
+		log.Printf("Unable to cache %s: %v", key, err)
 		return
 	}
+	err = s3upload(key+".sha256", strings.NewReader(hash+"\n"))
+	if err != nil {
+		log.Printf("Unable to record checksum of %s: %v", key, err)
+	}
+}
 
-	err = s3upload("game.tar.xz", fileRead)
+// prepareGetGameRepack turns the xz archive into a zstd one for faster launches next time, and caches that.
+func (server *FactorioServer) prepareGetGameRepack(key string) {
+	defer func() { _ = os.Remove(factorioArchivePath) }()
+	err := recompressToZstd(factorioArchivePath, factorioZstdArchivePath)
 	if err != nil {
+		log.Printf("Unable to repack the game: %v", err)
 		return
 	}
+	server.prepareGetGameUpload(factorioZstdArchivePath, key)
+	_ = os.Remove(factorioZstdArchivePath)
+}
+
+// checkSaveVersion refuses to go on with a save made by a newer Factorio than the one we are about to run, since the
+// game can only migrate saves forward.
+func (server *FactorioServer) checkSaveVersion() error {
+	header, err := readSaveHeaderFile(server.latestSave())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		log.Printf("Unable to read the save's header: %v", err)
+		if header.Version == (Version{}) {
+			return nil
+		}
+	}
+	if server.version.Less(header.Version) {
+		return fmt.Errorf("the save is from Factorio %v but this server runs %v; try `>version %v`",
+			header.Version, server.version, header.Version)
+	}
+	return nil
+}
 
-	_ = os.Remove("/tmp/game.tar.xz")
+// prepareGetState downloads the state, except what the game folder already has from an earlier session on the same
+// machine and nobody changed since.
+func (*FactorioServer) prepareGetState() error {
+	var worker ParallelWorker
+	objects, err := s3listRelevantObjects("state")
+	if err != nil {
+		return err
+	}
+	for name, object := range objects {
+		worker.Add(s3downloadIfChangedJob{"state/" + name, "game/" + name, object}.Run)
+	}
+	return worker.Join()
 }
 
-func (FactorioServer) prepareGetState() error {
+// saveState uploads the current save, and whatever else in the game folder came from the state prefix, back to it.
+func (server *FactorioServer) saveState() error {
+	folderTargets := []string{"mods", "config"}
+	fileExtensionTargets := []string{".json", ".dat", ".zip"}
+
 	var worker ParallelWorker
-	for name := range s3listRelevantObjects("state") {
-		if _, err := os.Stat("game/" + name); !errors.Is(err, os.ErrNotExist) {
-			continue // We already have the file
+	worker.Add(s3uploadJob{server.latestSave(), "state/save.zip"}.Run)
+	entries, err := os.ReadDir("game")
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		path := filepath.Join("game", name)
+		switch {
+		case entry.IsDir() && containsString(folderTargets, name):
+			if err = addStateFolderUploads(&worker, name); err != nil {
+				return err
+			}
+		case !entry.IsDir() && containsString(fileExtensionTargets, filepath.Ext(name)) && path != factorioInitialSavePath:
+			worker.Add(s3uploadJob{path, "state/" + name}.Run)
 		}
+	}
+	return worker.Join()
+}
 
-		worker.Add(s3downloadJob{"state/" + name, "game/" + name}.Run)
+// prepareGetUploads unpacks the mod packs people sent through Discord into the mods folder, then keeps the result in
+// the state instead, so each pack is only unpacked once.
+func (FactorioServer) prepareGetUploads() error {
+	names, err := s3listRelevantObjects(factorioUploadedModsPrefix)
+	if err != nil || len(names) == 0 {
+		return err
+	}
+	for name := range names {
+		key := factorioUploadedModsPrefix + name
+		path := filepath.Join(os.TempDir(), "narval-upload", filepath.Base(name))
+		found, err := s3downloadFile(key, path)
+		if !found {
+			continue
+		}
+		if err == nil {
+			err = installModUpload(path, factorioModsPath)
+		}
+		_ = os.Remove(path)
+		if err != nil {
+			sayInDiscord(fmt.Sprintf(":warning: Unable to use `%s`: %v", name, err))
+		}
+		if err = s3delete(key); err != nil {
+			return err
+		}
+	}
+
+	var worker ParallelWorker
+	if err = addStateFolderUploads(&worker, "mods"); err != nil {
+		return err
 	}
 	return worker.Join()
 }
 
-func (FactorioServer) saveState() {
-	// folderTargets := []string{"mods", "config"}
-	// fileExtensionTargets := []string{"json", "dat", "zip"}
+// addStateFolderUploads queues uploading everything in a folder of the game folder to the same place in the state.
+func addStateFolderUploads(worker *ParallelWorker, folder string) error {
+	return filepath.Walk(filepath.Join("game", folder), func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		relativePath, err := filepath.Rel("game", path)
+		if err != nil {
+			return err
+		}
+		worker.Add(s3uploadJob{path, "state/" + filepath.ToSlash(relativePath)}.Run)
+		return nil
+	})
+}
+
+// onSaved backs up the state every time the game saves, and tells a pending shutdown that it can go ahead.
+func (server *FactorioServer) onSaved() {
+	err := server.saveState()
+	if err != nil {
+		log.Printf("Unable to upload state: %v", err)
+	}
+	select {
+	case server.saved <- err:
+	default:
+	}
 }
 
 func (server *FactorioServer) Start() error {
 	server.players = map[User]bool{}
-	command := exec.Command(factorioBinaryPath, "--start-server", "game/save.zip")
-	stdout, _ := command.StdoutPipe()
-	server.in, _ = command.StdinPipe()
-	err := command.Start()
+	arguments := append([]string{"--start-server", server.latestSave()}, modArguments()...)
+	if _, err := os.Stat(factorioRunServerSettingsPath); err == nil {
+		arguments = append(arguments, "--server-settings", factorioRunServerSettingsPath)
+	}
+	if _, err := os.Stat(factorioServerAdminListPath); err == nil {
+		arguments = append(arguments, "--server-adminlist", factorioServerAdminListPath)
+	}
+	if _, err := os.Stat(factorioServerWhitelistPath); err == nil {
+		arguments = append(arguments, "--use-server-whitelist", "--server-whitelist", factorioServerWhitelistPath)
+	}
+	server.command = exec.Command(factorioBinaryPath, arguments...)
+	stdout, _ := server.command.StdoutPipe()
+	server.in, _ = server.command.StdinPipe()
+	err := server.command.Start()
 	if err != nil {
 		return err
 	}
 	server.out = make(chan ParsedLine, 100)
+	if server.policy == nil {
+		server.startPolicy()
+	} else {
+		server.policy.Restarted()
+	}
+	go server.readStdout(stdout)
+	return nil
+}
+
+func modArguments() []string {
+	if info, err := os.Stat(factorioModsPath); err == nil && info.IsDir() {
+		return []string{"--mod-directory", factorioModsPath}
+	}
+	return nil
+}
+
+// prepareCreateMap generates a new map when there is no save yet, using whatever map settings the channel chose.
+func (server *FactorioServer) prepareCreateMap() error {
+	if _, err := os.Stat(server.latestSave()); !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	arguments := []string{"--create", factorioInitialSavePath}
+	if _, err := os.Stat(factorioMapGenSettingsPath); err == nil {
+		arguments = append(arguments, "--map-gen-settings", factorioMapGenSettingsPath)
+	}
+	if _, err := os.Stat(factorioMapSettingsPath); err == nil {
+		arguments = append(arguments, "--map-settings", factorioMapSettingsPath)
+	}
+	arguments = append(arguments, modArguments()...)
 
-	startupGraceDuration, err := time.ParseDuration(os.Getenv("STARTUP_GRACE"))
+	log.Print("No save yet; creating a new map")
+	command := exec.Command(factorioBinaryPath, arguments...)
+	command.Stdout = os.Stdout
+	command.Stderr = os.Stderr
+	err := command.Run()
 	if err != nil {
-		startupGraceDuration = 5 * time.Minute
+		return fmt.Errorf("unable to create the map: %s", describeExit(err))
 	}
-	server.shutdownAt = time.Now().Add(startupGraceDuration)
+	return nil
+}
 
-	maxSessionDuration, err := time.ParseDuration(os.Getenv("MAX_SESSION"))
+// prepareServerSettings adds the factorio.com credentials to the channel's server settings, which public games need
+// to be listed.
+func prepareServerSettings() error {
+	buffer, err := os.ReadFile(factorioServerSettingsPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	settings := map[string]interface{}{}
+	if err = json.Unmarshal(buffer, &settings); err != nil {
+		return fmt.Errorf("server-settings.json: %w", err)
+	}
+	if username := os.Getenv("FACTORIO_USERNAME"); username != "" {
+		settings["username"] = username
+		settings["token"] = os.Getenv("FACTORIO_TOKEN")
+	}
+	buffer, err = json.Marshal(settings)
 	if err != nil {
-		startupGraceDuration = 24 * time.Hour
+		return err
 	}
-	server.maxSession = time.Now().Add(maxSessionDuration)
+	if err = os.MkdirAll(filepath.Dir(factorioRunServerSettingsPath), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(factorioRunServerSettingsPath, buffer, 0600)
+}
 
-	server.shutdownGrace, err = time.ParseDuration(os.Getenv("SHUTDOWN_GRACE"))
+// latestSave picks the most recent of the initial save and the autosaves, so a restart after a crash loses as little
+// progress as possible.
+func (FactorioServer) latestSave() string {
+	result := factorioInitialSavePath
+	var resultTime time.Time
+	if info, err := os.Stat(result); err == nil {
+		resultTime = info.ModTime()
+	}
+	autosaves, _ := filepath.Glob(filepath.Join(factorioWriteDataPath, "saves", "*.zip"))
+	for _, path := range autosaves {
+		info, err := os.Stat(path)
+		if err == nil && info.ModTime().After(resultTime) {
+			result, resultTime = path, info.ModTime()
+		}
+	}
+	return result
+}
+
+// interruptSaveTimeout is how long an interrupted server waits for its save, well within the two minutes AWS gives
+// before reclaiming a spot instance.
+const interruptSaveTimeout = 45 * time.Second
+
+func (server *FactorioServer) startPolicy() {
+	server.saved = make(chan error, 1)
+	server.policy = shutdownPolicyFromEnv()
+	server.policy.OnWarning = server.warnShutdown
+	server.policy.OnCancel = server.cancelShutdown
+	server.policy.OnShutdown = server.shutdown
+	server.policy.Start()
+	go stdinPassThrough(factorioStdin{server})
+}
+
+func (server *FactorioServer) warnShutdown(remaining time.Duration) {
+	message := fmt.Sprintf("Server shutting down in %v.", remaining)
+	sayInDiscord(":hourglass: " + message)
+	err := server.SendCommand(ParsedLine{Event: EventTalk, Message: message})
 	if err != nil {
-		server.shutdownGrace = 1 * time.Minute
+		log.Print(err)
 	}
+}
 
-	go server.readStdout(stdout)
-	go server.idleTimeout()
-	go stdinPassThrough(server.in)
-	return nil
+func (server *FactorioServer) cancelShutdown() {
+	message := "Shutdown cancelled, welcome back!"
+	sayInDiscord(":tada: " + message)
+	err := server.SendCommand(ParsedLine{Event: EventTalk, Message: message})
+	if err != nil {
+		log.Print(err)
+	}
 }
 
-func (server *FactorioServer) idleTimeout() {
-	// When it comes to polling intervals, I prefer using prime numbers. This is just under 3 seconds.
-	const interval time.Duration = 2718281831
-	for time.Now().Before(server.shutdownAt) {
-		time.Sleep(interval)
+// shutdown saves the game and waits for the state to be uploaded before quitting, unless someone shows up meanwhile.
+func (server *FactorioServer) shutdown() {
+	log.Printf("Saving before shutting down")
+	select {
+	case <-server.saved: // Forget about older saves
+	default:
+	}
+	err := server.SendCommand(ParsedLine{Event: EventSave})
+	if err != nil {
+		log.Print(err)
+	}
+
+	timeout := envDuration("SAVE_TIMEOUT", 2*time.Minute)
+	if server.policy.Interrupted() {
+		timeout = interruptSaveTimeout
+	}
+	select {
+	case err = <-server.saved:
+		if err != nil {
+			sayInDiscord(fmt.Sprintf(":warning: Unable to back up the game before shutting down: %v", err))
+		}
+	case <-time.After(timeout):
+		sayInDiscord(":warning: The game took too long to save; shutting down anyway.")
 	}
+
+	if server.policy.Cancel() {
+		server.cancelShutdown()
+		return
+	}
+
 	log.Printf("Shutting down!")
-	err := server.SendCommand(ParsedLine{Event: EventStop})
+	err = server.SendCommand(ParsedLine{Event: EventStop})
+	if err != nil {
+		log.Print(err)
+	}
+}
+
+func (server *FactorioServer) Interrupt(reason string) {
+	err := server.SendCommand(ParsedLine{Event: EventTalk, Message: reason})
 	if err != nil {
-		panic(err)
+		log.Print(err)
+	}
+	server.policy.Interrupt()
+}
+
+func (server *FactorioServer) Wait() error {
+	return server.command.Wait()
+}
+
+func (FactorioServer) LogFiles() []string {
+	result := []string{
+		filepath.Join(factorioWriteDataPath, "factorio-current.log"),
+		filepath.Join(factorioWriteDataPath, "factorio-previous.log"),
 	}
+	dumps, _ := filepath.Glob(filepath.Join(factorioWriteDataPath, "*.dmp"))
+	return append(result, dumps...)
+}
+
+func (stdin factorioStdin) Write(buffer []byte) (int, error) {
+	return stdin.server.in.Write(buffer)
 }
 
 func (server *FactorioServer) readStdout(stdout io.ReadCloser) {
@@ -194,7 +564,7 @@ func (server *FactorioServer) processLine(line string) (parsed ParsedLine) {
 
 	if factorioRegexpSaved.MatchString(line) {
 		parsed.Event = EventSaved
-		go server.saveState()
+		go server.onSaved()
 		return
 	}
 
@@ -215,9 +585,6 @@ func (server *FactorioServer) processLine(line string) (parsed ParsedLine) {
 		matches = factorioRegexpJoinLeave.FindStringSubmatch(line)
 		parsed.Author = User(matches[1])
 		delete(server.players, parsed.Author)
-		if len(server.players) == 0 {
-			server.shutdownAt = time.Now().Add(server.shutdownGrace)
-		}
 	case "CHAT":
 		parsed.Event = EventTalk
 		parsed.Message = line
@@ -228,25 +595,31 @@ func (server *FactorioServer) processLine(line string) (parsed ParsedLine) {
 		}
 	}
 
-	if len(server.players) != 0 || server.shutdownAt.After(server.maxSession) {
-		server.shutdownAt = server.maxSession
+	if parsed.Event == EventJoin || parsed.Event == EventLeave {
+		parsed.Players = len(server.players)
+		server.policy.PlayersChanged(parsed.Players)
 	}
 
 	return
 }
 
-func (server FactorioServer) NumPlayers() int {
-	return len(server.players)
-}
-
 func (server FactorioServer) GetLinesChannel() chan ParsedLine {
 	return server.out
 }
 
 func (server FactorioServer) SendCommand(line ParsedLine) error {
-	if line.Event == EventStop {
+	switch line.Event {
+	case EventStop:
 		_, err := server.in.Write([]byte("/quit\n"))
 		return err
+	case EventSave:
+		_, err := server.in.Write([]byte("/server-save\n"))
+		return err
+	case EventTalk:
+		// Anything that isn't a command is said in chat by the server; make sure it can't become one
+		message := strings.TrimLeft(strings.ReplaceAll(line.Message, "\n", " "), "/")
+		_, err := server.in.Write([]byte(message + "\n"))
+		return err
 	}
 	return errInvalidCommand
 }
@@ -5,17 +5,32 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 )
 
 type jsObj map[string]interface{}
 
+const imdsUrl = "http://169.254.169.254/latest/"
+
 var ipAddress string
+var ipv6Address string
 var ipAddressKnown = make(chan struct{})
+var stopRequested = make(chan struct{})
+var transferQuarters = map[string]int{}
+var transferQuartersLock sync.Mutex
+
+// playerLinks are the Discord users players linked themselves to, from PLAYER_LINKS: "name=id" lines.
+var playerLinks = map[User]string{}
 
 func Launch(what string) error {
 	var server Server
@@ -25,43 +40,185 @@ func Launch(what string) error {
 	default:
 		return errors.New("Server not defined: " + what)
 	}
+	envSession = os.Getenv("SESSION")
+	err := setupAws()
+	if err != nil {
+		return err
+	}
 	go fetchIpAddress()
+	onTransferProgress = reportTransferProgress
+	playerLinks = parsePlayerLinks(os.Getenv("PLAYER_LINKS"))
 
-	err := server.Prepare()
+	err = server.Prepare()
 	if err != nil {
+		sayInDiscord(fmt.Sprintf(":warning: Unable to prepare the server: %v", err))
 		return err
 	}
 
-	err = server.Start()
+	logFile, err := openSessionLog()
 	if err != nil {
 		return err
 	}
+	defer CloseDontCare(logFile)
+	stats := newSessionStats(time.Now())
+	defer finishSessionStats(stats)
 
-	for line := range server.GetLinesChannel() {
-		message := toMessage(server, line)
-		if message != "" {
-			sayInDiscord(message)
+	maxRestarts, err := strconv.Atoi(os.Getenv("MAX_RESTARTS"))
+	if err != nil {
+		maxRestarts = 0
+	}
+
+	for restarts := 0; ; restarts++ {
+		err = server.Start()
+		if err != nil {
+			return err
+		}
+		if restarts == 0 && os.Getenv("SPOT") != "" {
+			go watchSpotInterruption(server)
+		}
+		if restarts == 0 {
+			stopWatching := stopOnSignal(server)
+			defer stopWatching()
+		}
+
+		for line := range server.GetLinesChannel() {
+			logFile.Add(line.Raw)
+			stats.Record(line, time.Now())
+			message := toMessage(line)
+			if message != "" {
+				sayInDiscord(message)
+			}
+		}
+
+		exitErr := server.Wait()
+		stats.ServerStopped(time.Now())
+		if exitErr == nil && wasReclaimed() {
+			sayInDiscord(SpotReclaimedMessage)
+			return nil
+		}
+		if exitErr == nil {
+			sayInDiscord("Server shut down.")
+			return nil
 		}
+
+		log.Printf("Server %s", describeExit(exitErr))
+		sayInDiscord(crashSummary(exitErr, logFile.Tail()))
+		if err = uploadLogs(server); err != nil {
+			log.Print(err)
+		}
+		if restarts >= maxRestarts || wasReclaimed() || wasStopped() {
+			return exitErr
+		}
+		sayInDiscord(fmt.Sprintf("Restarting from the last save (attempt %d of %d)...", restarts+1, maxRestarts))
+	}
+}
+
+// stopOnSignal saves and quits when the launcher is asked to stop, like when the instance is terminated or the
+// container stopped, until the returned function is called.
+func stopOnSignal(server Server) func() {
+	signals := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		select {
+		case <-signals:
+			log.Printf("Told to stop; saving first")
+			close(stopRequested)
+			sayInDiscord(":octagonal_sign: Stopping the server.")
+			server.Interrupt("The server is being stopped; saving now.")
+		case <-done:
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+func wasStopped() bool {
+	select {
+	case <-stopRequested:
+		return true
+	default:
+		return false
+	}
+}
+
+func finishSessionStats(stats *sessionStats) {
+	summary := stats.Finish(time.Now())
+	sayInDiscord(summary.Describe())
+	if err := uploadSessionSummary(summary); err != nil {
+		log.Printf("Unable to upload the session summary: %v", err)
 	}
-	sayInDiscord("Server shut down.")
-	return nil
 }
 
-func toMessage(server Server, line ParsedLine) string {
+func toMessage(line ParsedLine) string {
 	switch line.Event {
 	case EventReady:
 		<-ipAddressKnown
-		return fmt.Sprintf("Server is ready! Ip address is %s", ipAddress)
+		// SERVER_PORT is where the game's port ends up when something like docker maps it to another one
+		port := ""
+		if value := os.Getenv("SERVER_PORT"); value != "" {
+			port = ":" + value
+		}
+		if ipv6Address != "" {
+			return fmt.Sprintf("Server is ready! Address is %s%s or [%s]%s", ipAddress, port, ipv6Address, port)
+		}
+		return fmt.Sprintf("Server is ready! Address is %s%s", ipAddress, port)
 	case EventJoin:
-		return fmt.Sprintf("`[%2d]` :star2: %s", server.NumPlayers(), line.Author)
+		return fmt.Sprintf("`[%2d]` :star2: %s", line.Players, displayName(line.Author))
 	case EventLeave:
-		return fmt.Sprintf("`[%2d]` :comet: %s", server.NumPlayers(), line.Author)
+		return fmt.Sprintf("`[%2d]` :comet: %s", line.Players, displayName(line.Author))
 	case EventTalk:
+		if id, found := playerLinks[line.Author]; found {
+			return fmt.Sprintf("<@%s> `<%s>` %s", id, line.Author, line.Message)
+		}
 		return fmt.Sprintf("`<%s>` %s", line.Author, line.Message)
 	}
 	return ""
 }
 
+func parsePlayerLinks(value string) map[User]string {
+	result := map[User]string{}
+	for _, line := range strings.Split(value, "\n") {
+		if separator := strings.LastIndex(line, "="); separator > 0 {
+			result[User(line[:separator])] = line[separator+1:]
+		}
+	}
+	return result
+}
+
+// displayName mentions whoever linked themselves to the player; mentions from the webhook never ping.
+func displayName(player User) string {
+	if id, found := playerLinks[player]; found {
+		return fmt.Sprintf("%s (<@%s>)", player, id)
+	}
+	return string(player)
+}
+
+// reportTransferProgress says in Discord how big transfers are going, every quarter of the way.
+func reportTransferProgress(progress TransferProgress) {
+	const minimumSize = 64 << 20
+	if progress.Total < minimumSize {
+		return
+	}
+	key := fmt.Sprintf("%v %s", progress.Upload, progress.Name)
+	quarter := int(4 * progress.Done / progress.Total)
+	transferQuartersLock.Lock()
+	reported, found := transferQuarters[key]
+	transferQuarters[key] = quarter
+	transferQuartersLock.Unlock()
+	if found && quarter <= reported {
+		return
+	}
+
+	verb := "Downloading"
+	if progress.Upload {
+		verb = "Uploading"
+	}
+	sayInDiscord(fmt.Sprintf("%s `%s`: %d%% of %.1f MiB", verb, progress.Name, 25*quarter, float64(progress.Total)/(1<<20)))
+}
+
 func sayInDiscord(message string) {
 	body := jsObj{
 		"content":          message,
@@ -79,22 +236,27 @@ func sayInDiscord(message string) {
 	}
 }
 
+// fetchIpAddress finds out where players can connect: SERVER_ADDRESS when the dispatcher has a stable one, then what
+// EC2 says the public addresses are, then whatever public looking address an interface has.
 func fetchIpAddress() {
-	const getIpUrl = "http://169.254.169.254/latest/meta-data/public-ipv4"
-	httpClient := http.Client{Timeout: 1 * time.Second}
-	response, err := httpClient.Get(getIpUrl)
-	if err == nil {
-		buffer := make([]byte, 20)
-		length, _ := response.Body.Read(buffer)
-		ipAddress = string(buffer[:length])
-		close(ipAddressKnown)
+	defer close(ipAddressKnown)
+	if address := os.Getenv("SERVER_ADDRESS"); address != "" {
+		ipAddress = address
 		return
 	}
+	if token, err := imdsToken(); err == nil {
+		ipAddress, _ = imdsGet(token, "public-ipv4")
+		ipv6Address, _ = imdsGet(token, "ipv6")
+		if ipAddress != "" {
+			return
+		}
+	}
 
 	interfaces, err := net.Interfaces()
 	if err != nil {
 		log.Panic(err)
 	}
+	var private string
 	for _, i := range interfaces {
 		addresses, err := i.Addrs()
 		if err != nil {
@@ -108,13 +270,55 @@ func fetchIpAddress() {
 			case *net.IPAddr:
 				ip = v.IP
 			}
-			if ip.To4() != nil && !ip.IsLoopback() {
+			switch {
+			case !ip.IsGlobalUnicast():
+			case ip.To4() == nil:
+				if ipv6Address == "" && !ip.IsPrivate() {
+					ipv6Address = ip.String()
+				}
+			case ip.IsPrivate():
+				if private == "" {
+					private = ip.String()
+				}
+			case ipAddress == "":
 				ipAddress = ip.String()
-				close(ipAddressKnown)
-				return
 			}
 		}
 	}
+	if ipAddress == "" {
+		ipAddress = private
+	}
+}
+
+// imdsToken starts an IMDSv2 session, which instances can require instead of answering plain requests.
+func imdsToken() (string, error) {
+	request, err := http.NewRequest(http.MethodPut, imdsUrl+"api/token", nil)
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "300")
+	return imdsDo(request)
+}
+
+func imdsGet(token, name string) (string, error) {
+	request, err := http.NewRequest(http.MethodGet, imdsUrl+"meta-data/"+name, nil)
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("X-aws-ec2-metadata-token", token)
+	return imdsDo(request)
+}
 
-	close(ipAddressKnown)
+func imdsDo(request *http.Request) (string, error) {
+	httpClient := http.Client{Timeout: 1 * time.Second}
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer CloseDontCare(response.Body)
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s answered %s", request.URL, response.Status)
+	}
+	buffer, err := io.ReadAll(io.LimitReader(response.Body, 4096))
+	return strings.TrimSpace(string(buffer)), err
 }
@@ -0,0 +1,99 @@
+package launchers
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// sessionLog keeps everything the game printed on disk so it can be uploaded after a crash, and remembers the last
+// few lines so they can be quoted in Discord.
+type sessionLog struct {
+	file *os.File
+	tail []string
+	next int
+}
+
+const sessionLogTailLines = 15
+const crashSummaryLineLength = 110
+
+var envSession string
+
+var sessionLogPath = filepath.Join(os.TempDir(), "narval-session.log")
+
+// openSessionLog starts the log afresh, so what gets uploaded is only this session's.
+func openSessionLog() (*sessionLog, error) {
+	file, err := os.OpenFile(sessionLogPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &sessionLog{file: file, tail: make([]string, 0, sessionLogTailLines)}, nil
+}
+
+func (logFile *sessionLog) Add(line string) {
+	_, _ = fmt.Fprintln(logFile.file, line)
+	if len(logFile.tail) < cap(logFile.tail) {
+		logFile.tail = append(logFile.tail, line)
+		return
+	}
+	logFile.tail[logFile.next] = line
+	logFile.next = (logFile.next + 1) % len(logFile.tail)
+}
+
+func (logFile *sessionLog) Tail() []string {
+	result := make([]string, 0, len(logFile.tail))
+	result = append(result, logFile.tail[logFile.next:]...)
+	return append(result, logFile.tail[:logFile.next]...)
+}
+
+func (logFile *sessionLog) Close() error {
+	return logFile.file.Close()
+}
+
+func sessionName() string {
+	if envSession != "" {
+		return envSession
+	}
+	return time.Now().UTC().Format("20060102-150405")
+}
+
+// uploadLogs copies the session log and whatever the server considers useful for a post-mortem to PREFIX/logs/.
+func uploadLogs(server Server) error {
+	folder := "logs/" + sessionName() + "/"
+	var worker ParallelWorker
+	for _, path := range append([]string{sessionLogPath}, server.LogFiles()...) {
+		path := path
+		worker.Add(func() error {
+			file, err := os.Open(path)
+			if errors.Is(err, os.ErrNotExist) {
+				return nil // Not every server leaves every kind of log behind
+			}
+			if err != nil {
+				return err
+			}
+			defer CloseDontCare(file)
+			return s3upload(folder+filepath.Base(path), file)
+		})
+	}
+	return worker.Join()
+}
+
+func crashSummary(exitErr error, tail []string) string {
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf(":boom: Server crashed (%s).", describeExit(exitErr)))
+	if len(tail) > 0 {
+		builder.WriteString(" Last lines:\n```\n")
+		for _, line := range tail {
+			if runes := []rune(line); len(runes) > crashSummaryLineLength {
+				line = string(runes[:crashSummaryLineLength]) + "…"
+			}
+			builder.WriteString(line)
+			builder.WriteString("\n")
+		}
+		builder.WriteString("```")
+	}
+	return builder.String()
+}
@@ -0,0 +1,45 @@
+package launchers
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestCrashSummaryCutsLongLinesBetweenRunes(t *testing.T) {
+	line := strings.Repeat("é", crashSummaryLineLength+10)
+	summary := crashSummary(errors.New("exit status 1"), []string{line})
+	if !utf8.ValidString(summary) {
+		t.Fatalf("summary isn't valid UTF-8: %q", summary)
+	}
+	if !strings.Contains(summary, strings.Repeat("é", crashSummaryLineLength)+"…\n") {
+		t.Errorf("line not cut at %d characters: %q", crashSummaryLineLength, summary)
+	}
+}
+
+func TestOpenSessionLogStartsEmpty(t *testing.T) {
+	previous := sessionLogPath
+	sessionLogPath = filepath.Join(t.TempDir(), "session.log")
+	defer func() { sessionLogPath = previous }()
+
+	for _, line := range []string{"first session", "second session"} {
+		logFile, err := openSessionLog()
+		if err != nil {
+			t.Fatal(err)
+		}
+		logFile.Add(line)
+		if err = logFile.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	buffer, err := os.ReadFile(sessionLogPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buffer) != "second session\n" {
+		t.Errorf("log is %q", buffer)
+	}
+}
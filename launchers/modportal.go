@@ -0,0 +1,334 @@
+package launchers
+
+import (
+	"archive/zip"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ModRelease is one version of a mod, as the portal describes it.
+type ModRelease struct {
+	DownloadUrl string `json:"download_url"`
+	FileName    string `json:"file_name"`
+	Version     string `json:"version"`
+	Sha1        string `json:"sha1"`
+	InfoJson    struct {
+		FactorioVersion string   `json:"factorio_version"`
+		Dependencies    []string `json:"dependencies"`
+	} `json:"info_json"`
+}
+
+// ModDependency is one entry of a mod's dependencies, such as "? bobores >= 1.1.0".
+type ModDependency struct {
+	Name     string
+	Kind     string // "" required, "?" optional, "(?)" hidden optional, "!" incompatible, "~" unordered
+	Operator string
+	Version  Version
+}
+
+// modResolver works out which mod files the game needs, fetching the missing ones from our cache or the portal.
+type modResolver struct {
+	gameVersion Version
+	modsPath    string
+	username    string
+	token       string
+	releases    map[string][]ModRelease
+	wanted      map[string]ModRelease
+}
+
+// modPortalUrl is a variable so tests can stand in for the mod portal.
+var modPortalUrl = "https://mods.factorio.com"
+
+const modCachePrefix = "/mods/"
+
+var modRegexpDependency = regexp.MustCompile(`^(!|\?|\(\?\)|~)?\s*([^<>=]+?)\s*(?:(<=|>=|<|>|=)\s*(\d+\.\d+\.\d+))?$`)
+var modRegexpFile = regexp.MustCompile(`^(.+)_(\d+\.\d+\.\d+)(\.zip)?$`)
+
+// builtInMods come with the game and are never downloaded.
+var builtInMods = map[string]bool{"base": true, "core": true, "space-age": true, "quality": true, "elevated-rails": true}
+
+func IsBuiltInMod(name string) bool {
+	return builtInMods[name]
+}
+
+func ParseModDependency(value string) (ModDependency, error) {
+	matches := modRegexpDependency.FindStringSubmatch(strings.TrimSpace(value))
+	if matches == nil {
+		return ModDependency{}, fmt.Errorf("not a dependency: %q", value)
+	}
+	dependency := ModDependency{Kind: matches[1], Name: matches[2], Operator: matches[3]}
+	if dependency.Operator != "" {
+		var err error
+		dependency.Version, err = ParseVersion(matches[4])
+		if err != nil {
+			return dependency, err
+		}
+	}
+	return dependency, nil
+}
+
+func (dependency ModDependency) Required() bool {
+	return dependency.Kind == "" || dependency.Kind == "~"
+}
+
+func (dependency ModDependency) Allows(version Version) bool {
+	switch dependency.Operator {
+	case "<":
+		return version.Less(dependency.Version)
+	case "<=":
+		return !dependency.Version.Less(version)
+	case ">":
+		return dependency.Version.Less(version)
+	case ">=":
+		return !version.Less(dependency.Version)
+	case "=":
+		return version == dependency.Version
+	}
+	return true
+}
+
+func newModResolver(gameVersion Version, modsPath string) *modResolver {
+	return &modResolver{
+		gameVersion: gameVersion,
+		modsPath:    modsPath,
+		username:    os.Getenv("FACTORIO_USERNAME"),
+		token:       os.Getenv("FACTORIO_TOKEN"),
+		releases:    map[string][]ModRelease{},
+		wanted:      map[string]ModRelease{},
+	}
+}
+
+// installedMods lists what is already in the mods folder, by name, with its version.
+func installedMods(modsPath string) map[string]Version {
+	result := map[string]Version{}
+	entries, _ := os.ReadDir(modsPath)
+	for _, entry := range entries {
+		matches := modRegexpFile.FindStringSubmatch(entry.Name())
+		if matches == nil || (matches[3] == "" && !entry.IsDir()) {
+			continue
+		}
+		version, err := ParseVersion(matches[2])
+		if err == nil && result[matches[1]].Less(version) {
+			result[matches[1]] = version
+		}
+	}
+	return result
+}
+
+// resolve picks a release for every enabled mod and, recursively, for whatever they require. Pinned versions win;
+// otherwise mods already in the folder are kept, and the newest release for our game version is used for the rest.
+func (resolver *modResolver) resolve(list *ModList, pins map[string]string) error {
+	installed := installedMods(resolver.modsPath)
+	var pending []ModDependency
+	for _, name := range list.EnabledNames() {
+		pending = append(pending, ModDependency{Name: name})
+	}
+	kept := map[string]bool{}
+	for len(pending) > 0 {
+		dependency := pending[0]
+		pending = pending[1:]
+		name := dependency.Name
+		if builtInMods[name] || resolver.isWanted(name) || kept[name] {
+			continue
+		}
+		keep := false
+		if pin, pinned := pins[name]; pinned {
+			version, err := ParseVersion(pin)
+			if err != nil {
+				return fmt.Errorf("pinned version of %s: %w", name, err)
+			}
+			keep = installed[name] == version
+		} else if version, found := installed[name]; found && dependency.Allows(version) {
+			keep = true
+		}
+
+		// What's kept still needs what it depends on, which may not be there
+		var dependencies []string
+		if keep {
+			kept[name] = true
+			var err error
+			dependencies, err = installedModDependencies(resolver.modsPath, name, installed[name])
+			if err != nil {
+				log.Printf("Unable to read the dependencies of %s: %v", name, err)
+			}
+		} else {
+			release, err := resolver.pickRelease(dependency, pins[name])
+			if err != nil {
+				return err
+			}
+			resolver.wanted[name] = release
+			dependencies = release.InfoJson.Dependencies
+		}
+		for _, value := range dependencies {
+			requirement, err := ParseModDependency(value)
+			if err != nil {
+				log.Printf("Ignoring dependency of %s: %v", name, err)
+				continue
+			}
+			if requirement.Required() && !builtInMods[requirement.Name] {
+				if !list.IsEnabled(requirement.Name) {
+					log.Printf("Enabling %s, required by %s", requirement.Name, name)
+					list.Enable(requirement.Name)
+				}
+				pending = append(pending, requirement)
+			}
+		}
+	}
+	return nil
+}
+
+// installedModDependencies reads the dependencies in the info.json of a mod in the folder, zipped or not.
+func installedModDependencies(modsPath, name string, version Version) ([]string, error) {
+	base := filepath.Join(modsPath, fmt.Sprintf("%s_%v", name, version))
+	buffer, err := os.ReadFile(filepath.Join(base, "info.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		buffer, err = readModZipInfo(base + ".zip")
+	}
+	if err != nil {
+		return nil, err
+	}
+	var info struct{ Dependencies []string }
+	err = json.Unmarshal(buffer, &info)
+	return info.Dependencies, err
+}
+
+// readModZipInfo reads the info.json right inside the one folder of a mod zip.
+func readModZipInfo(path string) ([]byte, error) {
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer CloseDontCare(archive)
+	for _, member := range archive.File {
+		if parts := strings.Split(member.Name, "/"); len(parts) == 2 && parts[1] == "info.json" {
+			reader, err := member.Open()
+			if err != nil {
+				return nil, err
+			}
+			defer CloseDontCare(reader)
+			return io.ReadAll(reader)
+		}
+	}
+	return nil, fmt.Errorf("no info.json in %s", filepath.Base(path))
+}
+
+func (resolver *modResolver) isWanted(name string) bool {
+	_, found := resolver.wanted[name]
+	return found
+}
+
+func (resolver *modResolver) pickRelease(dependency ModDependency, pin string) (ModRelease, error) {
+	releases, err := resolver.fetchReleases(dependency.Name)
+	if err != nil {
+		return ModRelease{}, err
+	}
+	gameMajorMinor := fmt.Sprintf("%d.%d", resolver.gameVersion[0], resolver.gameVersion[1])
+	var best ModRelease
+	var bestVersion Version
+	for _, release := range releases {
+		if pin != "" {
+			if release.Version == pin {
+				return release, nil
+			}
+			continue
+		}
+		version, err := ParseVersion(release.Version)
+		if err != nil || !dependency.Allows(version) || release.InfoJson.FactorioVersion != gameMajorMinor {
+			continue
+		}
+		if best.Version == "" || bestVersion.Less(version) {
+			best, bestVersion = release, version
+		}
+	}
+	if best.Version == "" {
+		return best, fmt.Errorf("no release of %s for Factorio %s", dependency.Name, gameMajorMinor)
+	}
+	return best, nil
+}
+
+func (resolver *modResolver) fetchReleases(name string) ([]ModRelease, error) {
+	if releases, found := resolver.releases[name]; found {
+		return releases, nil
+	}
+	httpClient := http.Client{Timeout: 30 * time.Second}
+	requestUrl := fmt.Sprintf("%s/api/mods/%s/full", modPortalUrl, url.PathEscape(name))
+	response, err := httpClient.Get(requestUrl)
+	if err != nil {
+		return nil, err
+	}
+	defer CloseDontCare(response.Body)
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("mod portal answered %s for %s", response.Status, name)
+	}
+	var body struct{ Releases []ModRelease }
+	if err = json.NewDecoder(response.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	resolver.releases[name] = body.Releases
+	return body.Releases, nil
+}
+
+// fetchAll puts every wanted release in the mods folder, from the guild's cache when possible.
+func (resolver *modResolver) fetchAll() error {
+	var worker ParallelWorker
+	for _, release := range resolver.wanted {
+		release := release
+		worker.Add(func() error { return resolver.fetch(release) })
+	}
+	return worker.Join()
+}
+
+func (resolver *modResolver) fetch(release ModRelease) error {
+	path := filepath.Join(resolver.modsPath, release.FileName)
+	key := modCachePrefix + release.FileName
+	found, err := s3downloadFile(key, path)
+	if found && err == nil {
+		return nil
+	}
+	if err != nil {
+		log.Printf("Unable to use cached %s: %v", key, err)
+	}
+
+	if resolver.username == "" || resolver.token == "" {
+		return fmt.Errorf("%s needs downloading from the mod portal; set credentials with `>modportal`", release.FileName)
+	}
+	query := url.Values{"username": {resolver.username}, "token": {resolver.token}}
+	log.Printf("Downloading %s from the mod portal", release.FileName)
+	response, err := http.Get(modPortalUrl + release.DownloadUrl + "?" + query.Encode())
+	if err != nil {
+		return err
+	}
+	defer CloseDontCare(response.Body)
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("mod portal answered %s for %s", response.Status, release.FileName)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	hash := sha1.New()
+	_, err = io.Copy(io.MultiWriter(file, hash), response.Body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && release.Sha1 != "" && hex.EncodeToString(hash.Sum(nil)) != release.Sha1 {
+		err = fmt.Errorf("%s doesn't match the portal's SHA-1", release.FileName)
+	}
+	if err != nil {
+		_ = os.Remove(path)
+		return err
+	}
+	return s3uploadFile(path, key)
+}
@@ -0,0 +1,253 @@
+package launchers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// testModPortal has releases for a few mods, each as "version factorio-version dependency, dependency...".
+var testModPortal = map[string][]string{
+	"alpha": {
+		"1.0.0 1.1 base >= 1.1.0, beta >= 2.0.0, ? gamma, ! delta, (?) epsilon",
+		"1.1.0 1.1 base >= 1.1.0, beta >= 2.0.0, ? gamma, ! delta, (?) epsilon",
+		"2.0.0 2.0 base >= 2.0.0",
+	},
+	"beta":    {"1.0.0 1.1", "2.0.0 1.1", "2.1.0 1.1"},
+	"gamma":   {"1.0.0 1.1"},
+	"delta":   {"1.0.0 1.1"},
+	"epsilon": {"1.0.0 1.1"},
+	"zeta":    {"1.0.0 1.1 ~ beta < 2.1.0"},
+	"old":     {"0.1.0 1.0"},
+}
+
+func useTestModPortal(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		name := strings.TrimSuffix(strings.TrimPrefix(request.URL.Path, "/api/mods/"), "/full")
+		releases, found := testModPortal[name]
+		if !found {
+			http.NotFound(writer, request)
+			return
+		}
+		var body struct {
+			Releases []ModRelease `json:"releases"`
+		}
+		for _, description := range releases {
+			fields := strings.SplitN(description, " ", 3)
+			release := ModRelease{Version: fields[0], FileName: name + "_" + fields[0] + ".zip"}
+			release.InfoJson.FactorioVersion = fields[1]
+			if len(fields) == 3 {
+				release.InfoJson.Dependencies = strings.Split(fields[2], ", ")
+			}
+			body.Releases = append(body.Releases, release)
+		}
+		_ = json.NewEncoder(writer).Encode(body)
+	}))
+	t.Cleanup(server.Close)
+	previous := modPortalUrl
+	modPortalUrl = server.URL
+	t.Cleanup(func() { modPortalUrl = previous })
+}
+
+// writeTestMod zips up a mod like the portal has it into the mods folder, with the dependencies it lists.
+func writeTestMod(t *testing.T, modsPath, file string) {
+	matches := modRegexpFile.FindStringSubmatch(file)
+	info := map[string]interface{}{"name": matches[1], "version": matches[2]}
+	for _, description := range testModPortal[matches[1]] {
+		if fields := strings.SplitN(description, " ", 3); fields[0] == matches[2] && len(fields) == 3 {
+			info["dependencies"] = strings.Split(fields[2], ", ")
+		}
+	}
+	buffer, err := json.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var zipped bytes.Buffer
+	zipWriter := zip.NewWriter(&zipped)
+	member, err := zipWriter.Create(strings.TrimSuffix(file, ".zip") + "/info.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = member.Write(buffer)
+	if err = zipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(modsPath, file), zipped.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestModResolution(t *testing.T) {
+	useTestModPortal(t)
+	for _, test := range []struct {
+		name      string
+		enabled   []string
+		pins      map[string]string
+		installed []string
+		wanted    map[string]string
+		enables   []string
+		fails     bool
+	}{
+		{
+			name:    "newest releases for the game, with what they require",
+			enabled: []string{"alpha"},
+			wanted:  map[string]string{"alpha": "1.1.0", "beta": "2.1.0"},
+			enables: []string{"alpha", "beta"},
+		},
+		{
+			name:    "optional dependencies only when enabled themselves",
+			enabled: []string{"alpha", "gamma"},
+			wanted:  map[string]string{"alpha": "1.1.0", "beta": "2.1.0", "gamma": "1.0.0"},
+			enables: []string{"alpha", "beta", "gamma"},
+		},
+		{
+			name:    "unordered dependencies with a version constraint",
+			enabled: []string{"zeta"},
+			wanted:  map[string]string{"zeta": "1.0.0", "beta": "2.0.0"},
+			enables: []string{"beta", "zeta"},
+		},
+		{
+			name:    "pinned versions win",
+			enabled: []string{"alpha"},
+			pins:    map[string]string{"alpha": "1.0.0", "beta": "2.0.0"},
+			wanted:  map[string]string{"alpha": "1.0.0", "beta": "2.0.0"},
+			enables: []string{"alpha", "beta"},
+		},
+		{
+			name:      "installed versions that fit are kept",
+			enabled:   []string{"alpha"},
+			installed: []string{"beta_2.0.0.zip"},
+			wanted:    map[string]string{"alpha": "1.1.0"},
+			enables:   []string{"alpha", "beta"},
+		},
+		{
+			name:      "installed mods still get what they require",
+			enabled:   []string{"alpha"},
+			installed: []string{"alpha_1.1.0.zip"},
+			wanted:    map[string]string{"beta": "2.1.0"},
+			enables:   []string{"alpha", "beta"},
+		},
+		{
+			name:      "installed versions that don't fit are replaced",
+			enabled:   []string{"alpha"},
+			installed: []string{"alpha_1.1.0.zip", "beta_1.0.0.zip"},
+			wanted:    map[string]string{"beta": "2.1.0"},
+			enables:   []string{"alpha", "beta"},
+		},
+		{
+			name:      "installed versions that aren't pinned are replaced",
+			enabled:   []string{"beta"},
+			pins:      map[string]string{"beta": "1.0.0"},
+			installed: []string{"beta_2.1.0.zip"},
+			wanted:    map[string]string{"beta": "1.0.0"},
+			enables:   []string{"beta"},
+		},
+		{
+			name:    "built-in mods",
+			enabled: []string{"base", "space-age"},
+			wanted:  map[string]string{},
+			enables: []string{"base", "space-age"},
+		},
+		{
+			name:    "pinned version that doesn't exist",
+			enabled: []string{"beta"},
+			pins:    map[string]string{"beta": "9.9.9"},
+			fails:   true,
+		},
+		{name: "invalid pin", enabled: []string{"beta"}, pins: map[string]string{"beta": "latest"}, fails: true},
+		{name: "no release for the game", enabled: []string{"old"}, fails: true},
+		{name: "not on the portal", enabled: []string{"missing"}, fails: true},
+	} {
+		modsPath := t.TempDir()
+		for _, file := range test.installed {
+			writeTestMod(t, modsPath, file)
+		}
+		list := &ModList{}
+		for _, name := range test.enabled {
+			list.Enable(name)
+		}
+		list.Mods = append(list.Mods, ModListEntry{"delta", false})
+
+		resolver := newModResolver(Version{1, 1, 110}, modsPath)
+		err := resolver.resolve(list, test.pins)
+		if test.fails {
+			if err == nil {
+				t.Errorf("%s: resolved %v", test.name, resolver.wanted)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		wanted := map[string]string{}
+		for name, release := range resolver.wanted {
+			wanted[name] = release.Version
+		}
+		if !reflect.DeepEqual(wanted, test.wanted) {
+			t.Errorf("%s: wants %v, expected %v", test.name, wanted, test.wanted)
+		}
+		enabled := list.EnabledNames()
+		sort.Strings(enabled)
+		if !reflect.DeepEqual(enabled, test.enables) {
+			t.Errorf("%s: enables %v, expected %v", test.name, enabled, test.enables)
+		}
+	}
+}
+
+func TestModDependencyParsing(t *testing.T) {
+	for value, expected := range map[string]ModDependency{
+		"beta":               {Name: "beta"},
+		"? gamma":            {Name: "gamma", Kind: "?"},
+		"(?) epsilon":        {Name: "epsilon", Kind: "(?)"},
+		"! delta":            {Name: "delta", Kind: "!"},
+		"~ beta < 2.1.0":     {Name: "beta", Kind: "~", Operator: "<", Version: Version{2, 1, 0}},
+		"base >= 1.1.0":      {Name: "base", Operator: ">=", Version: Version{1, 1, 0}},
+		"?Krastorio 2=1.0.0": {Name: "Krastorio 2", Kind: "?", Operator: "=", Version: Version{1, 0, 0}},
+	} {
+		dependency, err := ParseModDependency(value)
+		if err != nil {
+			t.Errorf("%q: %v", value, err)
+			continue
+		}
+		if dependency != expected {
+			t.Errorf("%q parsed as %+v", value, dependency)
+		}
+	}
+	if _, err := ParseModDependency("beta >= 1.0"); err == nil {
+		t.Error("parsed a version without a patch number")
+	}
+}
+
+func TestModDependencyAllows(t *testing.T) {
+	for _, test := range []struct {
+		dependency string
+		version    Version
+		allows     bool
+	}{
+		{"beta", Version{0, 0, 1}, true},
+		{"beta >= 2.0.0", Version{2, 0, 0}, true},
+		{"beta >= 2.0.0", Version{1, 9, 9}, false},
+		{"beta > 2.0.0", Version{2, 0, 0}, false},
+		{"beta <= 2.0.0", Version{2, 0, 0}, true},
+		{"beta < 2.0.0", Version{2, 0, 0}, false},
+		{"beta = 2.0.0", Version{2, 0, 0}, true},
+		{"beta = 2.0.0", Version{2, 0, 1}, false},
+	} {
+		dependency, err := ParseModDependency(test.dependency)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dependency.Allows(test.version) != test.allows {
+			t.Errorf("%s allowing %v should be %v", test.dependency, test.version, test.allows)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package launchers
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ModList is Factorio's mod-list.json, which says which of the mods in the folder are enabled.
+type ModList struct {
+	Mods []ModListEntry `json:"mods"`
+}
+
+type ModListEntry struct {
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+}
+
+const modListFile = "mod-list.json"
+
+// modVersionsFile is our own addition next to mod-list.json, pinning mods to exact versions: {"name": "1.2.3"}.
+const modVersionsFile = "mod-versions.json"
+
+func ReadModList(reader io.Reader) (*ModList, error) {
+	var list ModList
+	err := json.NewDecoder(reader).Decode(&list)
+	return &list, err
+}
+
+func (list *ModList) EnabledNames() []string {
+	var result []string
+	for _, entry := range list.Mods {
+		if entry.Enabled {
+			result = append(result, entry.Name)
+		}
+	}
+	return result
+}
+
+func (list *ModList) IsEnabled(name string) bool {
+	for _, entry := range list.Mods {
+		if entry.Name == name {
+			return entry.Enabled
+		}
+	}
+	return false
+}
+
+func (list *ModList) Enable(name string) {
+	for i := range list.Mods {
+		if list.Mods[i].Name == name {
+			list.Mods[i].Enabled = true
+			return
+		}
+	}
+	list.Mods = append(list.Mods, ModListEntry{name, true})
+}
+
+func readModListFile(modsPath string) (*ModList, error) {
+	file, err := os.Open(filepath.Join(modsPath, modListFile))
+	if err != nil {
+		return nil, err
+	}
+	defer CloseDontCare(file)
+	return ReadModList(file)
+}
+
+func writeModListFile(modsPath string, list *ModList) error {
+	buffer, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(modsPath, modListFile), buffer, 0644)
+}
+
+func readModVersionsFile(modsPath string) (map[string]string, error) {
+	pins := map[string]string{}
+	buffer, err := os.ReadFile(filepath.Join(modsPath, modVersionsFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return pins, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return pins, json.Unmarshal(buffer, &pins)
+}
+
+// prepareMods makes sure every mod mod-list.json enables, and everything those need, is in the mods folder.
+func prepareMods(gameVersion Version, modsPath string) error {
+	list, err := readModListFile(modsPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil // Vanilla
+	}
+	if err != nil {
+		return err
+	}
+	pins, err := readModVersionsFile(modsPath)
+	if err != nil {
+		return err
+	}
+	enabledBefore := len(list.EnabledNames())
+
+	resolver := newModResolver(gameVersion, modsPath)
+	if err = resolver.resolve(list, pins); err != nil {
+		return err
+	}
+	if err = resolver.fetchAll(); err != nil {
+		return err
+	}
+	if err = removeUnpinnedVersions(modsPath, pins); err != nil {
+		return err
+	}
+	if len(list.EnabledNames()) != enabledBefore {
+		return writeModListFile(modsPath, list)
+	}
+	return nil
+}
+
+// isFactorioModZip tells a single mod, which Factorio wants zipped as it is, from a pack of them: a mod has all of its
+// files in one folder, with an info.json right inside.
+func isFactorioModZip(path string) bool {
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		return false
+	}
+	defer CloseDontCare(archive)
+	var top string
+	hasInfo := false
+	for _, member := range archive.File {
+		parts := strings.SplitN(member.Name, "/", 2)
+		if len(parts) != 2 || (top != "" && parts[0] != top) {
+			return false
+		}
+		top = parts[0]
+		hasInfo = hasInfo || parts[1] == "info.json"
+	}
+	return hasInfo
+}
+
+// installModUpload puts an uploaded mod, or every mod in an uploaded pack, in the mods folder.
+func installModUpload(path, modsPath string) error {
+	if !isFactorioModZip(path) {
+		return extractArchive(path, modsPath)
+	}
+	if err := os.MkdirAll(modsPath, 0755); err != nil {
+		return err
+	}
+	source, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer CloseDontCare(source)
+	destination, err := os.Create(filepath.Join(modsPath, filepath.Base(path)))
+	if err != nil {
+		return err
+	}
+	defer CloseDontCare(destination)
+	_, err = io.Copy(destination, source)
+	return err
+}
+
+// removeUnpinnedVersions gets other versions of pinned mods out of the way, since Factorio loads the newest it finds.
+func removeUnpinnedVersions(modsPath string, pins map[string]string) error {
+	entries, err := os.ReadDir(modsPath)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		matches := modRegexpFile.FindStringSubmatch(entry.Name())
+		if matches == nil {
+			continue
+		}
+		if pin, pinned := pins[matches[1]]; !pinned || pin == matches[2] {
+			continue
+		}
+		log.Printf("Removing %s, since %s is pinned to %s", entry.Name(), matches[1], pins[matches[1]])
+		if err = os.RemoveAll(filepath.Join(modsPath, entry.Name())); err != nil {
+			return err
+		}
+		if !entry.IsDir() {
+			// Or else it comes back from the state next time
+			if err = s3delete("state/" + filepath.Base(modsPath) + "/" + entry.Name()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package launchers
+
+import (
+	"archive/zip"
+	"bufio"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path"
+)
+
+// SaveHeader is what a Factorio save says about itself at the very start of its level data.
+type SaveHeader struct {
+	Version Version
+	Mods    []SaveMod
+}
+
+// SaveMod is a mod the save was last played with.
+type SaveMod struct {
+	Name    string
+	Version Version
+}
+
+// saveReader reads the space optimized encoding Factorio uses in level data, remembering the first error.
+type saveReader struct {
+	reader io.Reader
+	err    error
+}
+
+var ErrNotASave = errors.New("not a Factorio save: no level data found")
+var errUnexpectedSaveLayout = errors.New("unexpected save header layout")
+
+// ReadSaveHeader finds the level data inside a save zip and decodes its header.
+func ReadSaveHeader(reader io.ReaderAt, size int64) (SaveHeader, error) {
+	var header SaveHeader
+	archive, err := zip.NewReader(reader, size)
+	if err != nil {
+		return header, err
+	}
+	var level *zip.File
+	for _, file := range archive.File {
+		switch path.Base(file.Name) {
+		case "level-init.dat":
+			level = file
+		case "level.dat0", "level.dat":
+			if level == nil {
+				level = file
+			}
+		}
+	}
+	if level == nil {
+		return header, ErrNotASave
+	}
+
+	compressed, err := level.Open()
+	if err != nil {
+		return header, err
+	}
+	defer CloseDontCare(compressed)
+	data, err := maybeZlib(compressed)
+	if err != nil {
+		return header, err
+	}
+	return decodeSaveHeader(data)
+}
+
+// maybeZlib undoes the extra zlib layer newer saves wrap their level data in.
+func maybeZlib(reader io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(reader)
+	magic, err := buffered.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if magic[0] == 0x78 {
+		return zlib.NewReader(buffered)
+	}
+	return buffered, nil
+}
+
+func decodeSaveHeader(reader io.Reader) (SaveHeader, error) {
+	var header SaveHeader
+	var version [4]uint16 // the fourth is the build
+	err := binary.Read(reader, binary.LittleEndian, &version)
+	if err != nil {
+		return header, err
+	}
+	copy(header.Version[:], version[:3])
+	header.Mods, err = decodeSaveMods(&saveReader{reader: reader}, header.Version)
+	return header, err
+}
+
+// decodeSaveMods skips over the scenario details that follow the version and reads the mod list.
+func decodeSaveMods(reader *saveReader, version Version) ([]SaveMod, error) {
+	if !version.Less(Version{0, 18, 0}) {
+		reader.u8() // branch
+	}
+	reader.string() // campaign
+	reader.string() // level name
+	reader.string() // base mod
+	reader.u8()     // difficulty
+	reader.u8()     // finished
+	reader.u8()     // player won
+	reader.string() // next level
+	reader.u8()     // can continue
+	reader.u8()     // finished but continuing
+	reader.u8()     // saving replay
+	if !version.Less(Version{0, 16, 0}) {
+		reader.u8() // allow non-admin debug options
+	}
+	reader.shortVersion() // loaded from
+	reader.u16()          // loaded from build
+	if !version.Less(Version{0, 16, 0}) {
+		reader.u8() // allowed commands
+	}
+
+	count := reader.count()
+	if reader.err != nil {
+		return nil, reader.err
+	}
+	if count > 10000 {
+		return nil, errUnexpectedSaveLayout
+	}
+	mods := make([]SaveMod, 0, count)
+	for i := uint32(0); i < count; i++ {
+		mod := SaveMod{Name: reader.string(), Version: reader.shortVersion()}
+		if !version.Less(Version{0, 15, 0}) {
+			reader.u32() // CRC
+		}
+		mods = append(mods, mod)
+	}
+	if reader.err != nil {
+		return nil, reader.err
+	}
+	if len(mods) == 0 || mods[0].Name != "base" {
+		return nil, errUnexpectedSaveLayout // every save has base, first
+	}
+	return mods, nil
+}
+
+func (reader *saveReader) read(value interface{}) {
+	if reader.err == nil {
+		reader.err = binary.Read(reader.reader, binary.LittleEndian, value)
+	}
+}
+
+func (reader *saveReader) u8() (value uint8) {
+	reader.read(&value)
+	return
+}
+
+func (reader *saveReader) u16() (value uint16) {
+	reader.read(&value)
+	return
+}
+
+func (reader *saveReader) u32() (value uint32) {
+	reader.read(&value)
+	return
+}
+
+// count is a byte, unless that byte is 255, in which case a 32 bit number follows.
+func (reader *saveReader) count() uint32 {
+	if value := reader.u8(); value != 0xff {
+		return uint32(value)
+	}
+	return reader.u32()
+}
+
+// shortVersion is three numbers, each a byte unless that byte is 255, in which case a 16 bit number follows.
+func (reader *saveReader) shortVersion() (version Version) {
+	for i := range version {
+		if value := reader.u8(); value != 0xff {
+			version[i] = uint16(value)
+		} else {
+			version[i] = reader.u16()
+		}
+	}
+	return
+}
+
+func (reader *saveReader) string() string {
+	length := reader.count()
+	if reader.err != nil {
+		return ""
+	}
+	if length > 1<<16 {
+		reader.err = errUnexpectedSaveLayout
+		return ""
+	}
+	buffer := make([]byte, length)
+	_, err := io.ReadFull(reader.reader, buffer)
+	if reader.err == nil {
+		reader.err = err
+	}
+	return string(buffer)
+}
+
+func readSaveHeaderFile(filename string) (SaveHeader, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return SaveHeader{}, err
+	}
+	defer CloseDontCare(file)
+	info, err := file.Stat()
+	if err != nil {
+		return SaveHeader{}, err
+	}
+	return ReadSaveHeader(file, info.Size())
+}
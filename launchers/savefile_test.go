@@ -0,0 +1,57 @@
+package launchers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func saveString(buffer *bytes.Buffer, value string) {
+	buffer.WriteByte(byte(len(value)))
+	buffer.WriteString(value)
+}
+
+func TestDecodeSaveHeaderReadsMods(t *testing.T) {
+	var buffer bytes.Buffer
+	_ = binary.Write(&buffer, binary.LittleEndian, [4]uint16{1, 1, 110, 60000})
+	buffer.WriteByte(0) // branch
+	saveString(&buffer, "")
+	saveString(&buffer, "freeplay")
+	saveString(&buffer, "base")
+	buffer.Write([]byte{1, 0, 0})
+	saveString(&buffer, "")
+	buffer.Write([]byte{0, 0, 0, 0})
+	buffer.Write([]byte{1, 1, 110, 0x60, 0xea, 0})
+	buffer.WriteByte(2)
+	saveString(&buffer, "base")
+	buffer.Write([]byte{1, 1, 110, 1, 2, 3, 4})
+	saveString(&buffer, "long-versioned")
+	buffer.Write([]byte{0xff, 0x2c, 0x01, 2, 3, 1, 2, 3, 4}) // 300.2.3
+
+	header, err := decodeSaveHeader(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Version != (Version{1, 1, 110}) {
+		t.Errorf("version is %v", header.Version)
+	}
+	want := []SaveMod{{"base", Version{1, 1, 110}}, {"long-versioned", Version{300, 2, 3}}}
+	if len(header.Mods) != len(want) || header.Mods[0] != want[0] || header.Mods[1] != want[1] {
+		t.Errorf("mods are %v, want %v", header.Mods, want)
+	}
+}
+
+func TestDecodeSaveHeaderKeepsVersionOfOddLayouts(t *testing.T) {
+	var buffer bytes.Buffer
+	_ = binary.Write(&buffer, binary.LittleEndian, [4]uint16{2, 0, 7, 0})
+	buffer.Write(make([]byte, 40))
+
+	header, err := decodeSaveHeader(&buffer)
+	if !errors.Is(err, errUnexpectedSaveLayout) {
+		t.Errorf("got %v, want %v", err, errUnexpectedSaveLayout)
+	}
+	if header.Version != (Version{2, 0, 7}) {
+		t.Errorf("version is %v", header.Version)
+	}
+}
@@ -0,0 +1,317 @@
+package launchers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ShutdownPolicy decides when an empty (or simply too old) server should go away, and warns everyone before it does.
+type ShutdownPolicy struct {
+	StartupGrace time.Duration
+	IdleGrace    time.Duration
+	MaxSession   time.Duration
+	QuietHours   *QuietHours
+	Warnings     []time.Duration
+	OnWarning    func(remaining time.Duration)
+	OnCancel     func()
+	OnShutdown   func()
+
+	lock          sync.Mutex
+	timer         *time.Timer
+	startedAt     time.Time
+	emptyAt       time.Time
+	grace         time.Duration
+	players       int
+	deadline      time.Time
+	interruptedAt time.Time
+	warned        int
+	announced     bool
+	stopped       bool
+}
+
+// QuietHours is a daily window in which servers are not supposed to be running.
+type QuietHours struct {
+	From, To time.Duration // since midnight
+	Location *time.Location
+}
+
+// shutdownRounding absorbs the little time that passes between setting a deadline and arming the timer for it, so a
+// warning as long as the grace still goes out. Tests lower it along with their durations.
+var shutdownRounding = time.Second
+
+var errInvalidQuietHours = errors.New("quiet hours must look like 23:00-07:00")
+
+// DefaultMaxSession is how long a session can last when MAX_SESSION doesn't say.
+const DefaultMaxSession = 24 * time.Hour
+
+func shutdownPolicyFromEnv() *ShutdownPolicy {
+	policy := &ShutdownPolicy{
+		StartupGrace: envDuration("STARTUP_GRACE", 5*time.Minute),
+		IdleGrace:    envDuration("SHUTDOWN_GRACE", 1*time.Minute),
+		MaxSession:   envDuration("MAX_SESSION", DefaultMaxSession),
+		Warnings:     []time.Duration{5 * time.Minute, 1 * time.Minute, 10 * time.Second},
+	}
+	if value := os.Getenv("QUIET_HOURS"); value != "" {
+		quietHours, err := ParseQuietHours(value, os.Getenv("TIMEZONE"))
+		if err != nil {
+			log.Printf("Ignoring QUIET_HOURS: %v", err)
+		} else {
+			policy.QuietHours = quietHours
+		}
+	}
+	return policy
+}
+
+func envDuration(name string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(name))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+// Start begins counting the startup grace from now.
+func (policy *ShutdownPolicy) Start() {
+	policy.lock.Lock()
+	defer policy.lock.Unlock()
+	policy.startedAt = time.Now()
+	policy.emptyAt = policy.startedAt
+	policy.grace = policy.StartupGrace
+	policy.stopped = false
+	policy.reschedule()
+}
+
+// Restarted is called when the game process came back without anyone in it; the startup grace applies again, but
+// the session length and quiet hours keep counting from the original start.
+func (policy *ShutdownPolicy) Restarted() {
+	policy.lock.Lock()
+	defer policy.lock.Unlock()
+	policy.players = 0
+	policy.emptyAt = time.Now()
+	policy.grace = policy.StartupGrace
+	policy.reschedule()
+}
+
+func (policy *ShutdownPolicy) PlayersChanged(players int) {
+	policy.lock.Lock()
+	if players == 0 && policy.players != 0 {
+		policy.emptyAt = time.Now()
+		policy.grace = policy.IdleGrace
+	}
+	policy.players = players
+	cancelled := policy.reschedule()
+	policy.lock.Unlock()
+	if cancelled && policy.OnCancel != nil {
+		policy.OnCancel()
+	}
+}
+
+// Cancel undoes a shutdown that already fired, as long as someone is playing and nothing else demands it right now.
+func (policy *ShutdownPolicy) Cancel() bool {
+	policy.lock.Lock()
+	defer policy.lock.Unlock()
+	if !policy.stopped || policy.players == 0 {
+		return false
+	}
+	deadline := policy.computeDeadline()
+	if !deadline.IsZero() && !deadline.After(time.Now()) {
+		return false
+	}
+	policy.stopped = false
+	policy.reschedule()
+	return true
+}
+
+// Interrupt shuts down now, without warnings and without letting anyone cancel it.
+func (policy *ShutdownPolicy) Interrupt() {
+	policy.lock.Lock()
+	defer policy.lock.Unlock()
+	policy.interruptedAt = time.Now()
+	policy.reschedule()
+}
+
+// Interrupted tells whether the shutdown comes from Interrupt, which leaves little time to save.
+func (policy *ShutdownPolicy) Interrupted() bool {
+	policy.lock.Lock()
+	defer policy.lock.Unlock()
+	return !policy.interruptedAt.IsZero()
+}
+
+func (policy *ShutdownPolicy) Stop() {
+	policy.lock.Lock()
+	defer policy.lock.Unlock()
+	policy.stopped = true
+	if policy.timer != nil {
+		policy.timer.Stop()
+	}
+}
+
+// Deadline is when the server will shut down if nothing changes; zero if never.
+func (policy *ShutdownPolicy) Deadline() time.Time {
+	policy.lock.Lock()
+	defer policy.lock.Unlock()
+	return policy.deadline
+}
+
+func (policy *ShutdownPolicy) computeDeadline() time.Time {
+	var result time.Time
+	consider := func(candidate time.Time) {
+		if result.IsZero() || candidate.Before(result) {
+			result = candidate
+		}
+	}
+	if policy.MaxSession > 0 {
+		consider(policy.startedAt.Add(policy.MaxSession))
+	}
+	if policy.QuietHours != nil {
+		// A session that only got going once quiet hours began doesn't get to run through them
+		if _, quiet := policy.QuietHours.End(policy.startedAt); quiet {
+			consider(policy.startedAt)
+		} else {
+			consider(policy.QuietHours.NextStart(policy.startedAt))
+		}
+	}
+	if policy.players == 0 {
+		consider(policy.emptyAt.Add(policy.grace))
+	}
+	if !policy.interruptedAt.IsZero() {
+		consider(policy.interruptedAt)
+	}
+	return result
+}
+
+// reschedule arms the timer for the next warning or for the shutdown itself, and tells whether an announced shutdown
+// got postponed. Must be called with the lock held.
+func (policy *ShutdownPolicy) reschedule() (cancelled bool) {
+	if policy.timer != nil {
+		policy.timer.Stop()
+	}
+	if policy.stopped {
+		return
+	}
+	deadline := policy.computeDeadline()
+	if !deadline.Equal(policy.deadline) {
+		cancelled = policy.announced && (deadline.IsZero() || deadline.After(policy.deadline))
+		if cancelled {
+			policy.announced = false
+		}
+		policy.deadline = deadline
+		policy.warned = 0
+	}
+	if deadline.IsZero() {
+		return
+	}
+
+	remaining := time.Until(deadline).Round(shutdownRounding)
+	for policy.warned < len(policy.Warnings) && policy.Warnings[policy.warned] > remaining {
+		policy.warned++ // Too late for this one
+	}
+	if policy.warned < len(policy.Warnings) {
+		warning := policy.Warnings[policy.warned]
+		policy.timer = time.AfterFunc(remaining-warning, func() { policy.fireWarning(deadline, warning) })
+	} else {
+		policy.timer = time.AfterFunc(remaining, func() { policy.fireShutdown(deadline) })
+	}
+	return
+}
+
+func (policy *ShutdownPolicy) fireWarning(deadline time.Time, warning time.Duration) {
+	policy.lock.Lock()
+	if policy.stopped || !policy.deadline.Equal(deadline) {
+		policy.lock.Unlock()
+		return // Things changed while the timer was firing
+	}
+	policy.warned++
+	policy.announced = true
+	policy.reschedule()
+	policy.lock.Unlock()
+	if policy.OnWarning != nil {
+		policy.OnWarning(warning)
+	}
+}
+
+func (policy *ShutdownPolicy) fireShutdown(deadline time.Time) {
+	policy.lock.Lock()
+	if policy.stopped || !policy.deadline.Equal(deadline) {
+		policy.lock.Unlock()
+		return
+	}
+	policy.stopped = true
+	policy.announced = false
+	policy.lock.Unlock()
+	if policy.OnShutdown != nil {
+		policy.OnShutdown()
+	}
+}
+
+// ParseQuietHours reads a window such as "23:00-07:00" in the given IANA time zone (UTC if empty).
+func ParseQuietHours(window, timezone string) (*QuietHours, error) {
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.Split(window, "-")
+	if len(parts) != 2 {
+		return nil, errInvalidQuietHours
+	}
+	from, err := parseTimeOfDay(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	to, err := parseTimeOfDay(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	if from == to {
+		return nil, errInvalidQuietHours
+	}
+	return &QuietHours{from, to, location}, nil
+}
+
+func parseTimeOfDay(value string) (time.Duration, error) {
+	parsed, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, errInvalidQuietHours
+	}
+	return time.Duration(parsed.Hour())*time.Hour + time.Duration(parsed.Minute())*time.Minute, nil
+}
+
+// NextStart is the first time quiet hours begin after the given moment.
+func (quiet *QuietHours) NextStart(after time.Time) time.Time {
+	local := after.In(quiet.Location)
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, quiet.Location)
+	start := midnight.Add(quiet.From)
+	if !start.After(after) {
+		start = time.Date(local.Year(), local.Month(), local.Day()+1, 0, 0, 0, 0, quiet.Location).Add(quiet.From)
+	}
+	return start
+}
+
+// End tells when the quiet hours the given moment falls in are over, if it falls in any.
+func (quiet *QuietHours) End(at time.Time) (time.Time, bool) {
+	local := at.In(quiet.Location)
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, quiet.Location)
+	sinceMidnight := local.Sub(midnight)
+	switch {
+	case quiet.From < quiet.To && sinceMidnight >= quiet.From && sinceMidnight < quiet.To:
+		return midnight.Add(quiet.To), true
+	case quiet.From > quiet.To && sinceMidnight < quiet.To:
+		return midnight.Add(quiet.To), true
+	case quiet.From > quiet.To && sinceMidnight >= quiet.From:
+		tomorrow := time.Date(local.Year(), local.Month(), local.Day()+1, 0, 0, 0, 0, quiet.Location)
+		return tomorrow.Add(quiet.To), true
+	}
+	return time.Time{}, false
+}
+
+func (quiet *QuietHours) String() string {
+	format := func(value time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", int(value.Hours()), int(value.Minutes())%60)
+	}
+	return fmt.Sprintf("%s-%s %s", format(quiet.From), format(quiet.To), quiet.Location)
+}
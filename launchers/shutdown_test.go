@@ -0,0 +1,232 @@
+package launchers
+
+import (
+	"narval/fakes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestQuietHoursEnd(t *testing.T) {
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2021, time.June, day, hour, minute, 0, 0, time.UTC)
+	}
+	for _, test := range []struct {
+		window string
+		when   time.Time
+		end    time.Time
+	}{
+		{"23:00-07:00", at(4, 23, 30), at(5, 7, 0)},
+		{"23:00-07:00", at(5, 6, 59), at(5, 7, 0)},
+		{"23:00-07:00", at(5, 7, 0), time.Time{}},
+		{"23:00-07:00", at(4, 22, 59), time.Time{}},
+		{"01:00-06:00", at(5, 1, 0), at(5, 6, 0)},
+		{"01:00-06:00", at(5, 0, 30), time.Time{}},
+	} {
+		quiet, err := ParseQuietHours(test.window, "UTC")
+		if err != nil {
+			t.Fatal(err)
+		}
+		end, found := quiet.End(test.when)
+		if found != !test.end.IsZero() || !end.Equal(test.end) {
+			t.Errorf("%s at %v ends %v (%v), expected %v", test.window, test.when, end, found, test.end)
+		}
+	}
+}
+
+// shutdownRecorder notes what a policy does, in order.
+type shutdownRecorder struct {
+	lock   sync.Mutex
+	events []string
+}
+
+func (recorder *shutdownRecorder) note(event string) {
+	recorder.lock.Lock()
+	recorder.events = append(recorder.events, event)
+	recorder.lock.Unlock()
+}
+
+func (recorder *shutdownRecorder) seen() []string {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+	return append([]string(nil), recorder.events...)
+}
+
+// recordedPolicy notes what the policy does, with timers precise enough for durations in milliseconds.
+func recordedPolicy(t *testing.T, policy *ShutdownPolicy) *shutdownRecorder {
+	previous := shutdownRounding
+	shutdownRounding = 10 * time.Millisecond
+	t.Cleanup(func() { shutdownRounding = previous })
+	recorder := &shutdownRecorder{}
+	policy.OnWarning = func(remaining time.Duration) { recorder.note("warning " + remaining.String()) }
+	policy.OnCancel = func() { recorder.note("cancel") }
+	policy.OnShutdown = func() { recorder.note("shutdown") }
+	return recorder
+}
+
+// waitFor polls until the condition holds, failing the test if it takes more than a couple of seconds.
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); !condition(); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+func TestShutdownCountdown(t *testing.T) {
+	policy := &ShutdownPolicy{StartupGrace: 300 * time.Millisecond,
+		Warnings: []time.Duration{200 * time.Millisecond, 100 * time.Millisecond}}
+	recorder := recordedPolicy(t, policy)
+	policy.Start()
+	defer policy.Stop()
+	waitFor(t, "the shutdown", func() bool { return len(recorder.seen()) == 3 })
+	expected := []string{"warning 200ms", "warning 100ms", "shutdown"}
+	if events := recorder.seen(); !reflect.DeepEqual(events, expected) {
+		t.Errorf("went %v, expected %v", events, expected)
+	}
+}
+
+func TestShutdownCancelledByPlayer(t *testing.T) {
+	policy := &ShutdownPolicy{StartupGrace: 200 * time.Millisecond, IdleGrace: 100 * time.Millisecond,
+		Warnings: []time.Duration{150 * time.Millisecond}}
+	recorder := recordedPolicy(t, policy)
+	policy.Start()
+	defer policy.Stop()
+	waitFor(t, "the warning", func() bool { return len(recorder.seen()) == 1 })
+
+	policy.PlayersChanged(1)
+	if !policy.Deadline().IsZero() {
+		t.Errorf("shutting down at %v with someone playing", policy.Deadline())
+	}
+	time.Sleep(300 * time.Millisecond)
+	if events := recorder.seen(); !reflect.DeepEqual(events, []string{"warning 150ms", "cancel"}) {
+		t.Fatalf("went %v with someone playing", events)
+	}
+
+	// The idle grace is shorter than the warning, so there's no warning this time
+	policy.PlayersChanged(0)
+	waitFor(t, "the shutdown", func() bool { return len(recorder.seen()) == 3 })
+	if events := recorder.seen(); events[2] != "shutdown" {
+		t.Errorf("went %v once empty", events)
+	}
+}
+
+func TestShutdownAtMaxSessionDespitePlayers(t *testing.T) {
+	policy := &ShutdownPolicy{StartupGrace: time.Hour, MaxSession: 100 * time.Millisecond}
+	recorder := recordedPolicy(t, policy)
+	policy.Start()
+	defer policy.Stop()
+	policy.PlayersChanged(1)
+	waitFor(t, "the shutdown", func() bool { return len(recorder.seen()) == 1 })
+	if policy.Cancel() {
+		t.Error("someone playing cancelled a shutdown for the session being over")
+	}
+}
+
+func TestShutdownCancelledWhileSaving(t *testing.T) {
+	policy := &ShutdownPolicy{StartupGrace: 50 * time.Millisecond, IdleGrace: time.Hour}
+	recorder := recordedPolicy(t, policy)
+	policy.Start()
+	defer policy.Stop()
+	waitFor(t, "the shutdown", func() bool { return len(recorder.seen()) == 1 })
+	if policy.Cancel() {
+		t.Error("cancelled with nobody there")
+	}
+	policy.PlayersChanged(1)
+	if !policy.Cancel() {
+		t.Error("someone joining while saving didn't cancel")
+	}
+}
+
+// commandRecorder stands in for the game's stdin.
+type commandRecorder struct {
+	lock  sync.Mutex
+	lines []string
+}
+
+func (recorder *commandRecorder) Write(buffer []byte) (int, error) {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+	recorder.lines = append(recorder.lines, strings.TrimSpace(string(buffer)))
+	return len(buffer), nil
+}
+
+func (recorder *commandRecorder) Close() error {
+	return nil
+}
+
+func (recorder *commandRecorder) sent(line string) bool {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+	return containsString(recorder.lines, line)
+}
+
+// shuttingDownServer is a server whose shutdown just fired, in a game folder with a save.
+func shuttingDownServer(t *testing.T) (*FactorioServer, *commandRecorder, *fakes.S3) {
+	fake := useTestS3(t)
+	webhook := httptest.NewServer(http.NotFoundHandler())
+	t.Cleanup(webhook.Close)
+	t.Setenv("WEBHOOK_URL", webhook.URL)
+
+	previous, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Chdir(previous) })
+	if err = os.MkdirAll("game", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(factorioInitialSavePath, []byte("save"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	stdin := &commandRecorder{}
+	server := &FactorioServer{players: map[User]bool{}, in: stdin, saved: make(chan error, 1),
+		policy: &ShutdownPolicy{}}
+	return server, stdin, fake
+}
+
+func TestShutdownWaitsForTheSaveToBeUploaded(t *testing.T) {
+	server, stdin, fake := shuttingDownServer(t)
+	done := make(chan struct{})
+	go func() {
+		server.shutdown()
+		close(done)
+	}()
+	waitFor(t, "the save command", func() bool { return stdin.sent("/server-save") })
+	time.Sleep(100 * time.Millisecond)
+	if stdin.sent("/quit") {
+		t.Fatal("quit before the game saved")
+	}
+
+	server.processLine("   5.000 Info AppManagerStates.cpp:1: Saving finished")
+	<-done
+	if !stdin.sent("/quit") {
+		t.Error("didn't quit once saved")
+	}
+	if save, _ := fake.Object("bucket", "channel/state/save.zip"); string(save) != "save" {
+		t.Errorf("quit with %q uploaded as the save", save)
+	}
+}
+
+func TestShutdownGivesUpOnSlowSaves(t *testing.T) {
+	server, stdin, _ := shuttingDownServer(t)
+	t.Setenv("SAVE_TIMEOUT", "100ms")
+	started := time.Now()
+	server.shutdown()
+	if !stdin.sent("/quit") {
+		t.Error("didn't quit without a save")
+	}
+	if elapsed := time.Since(started); elapsed < 100*time.Millisecond {
+		t.Errorf("only waited %v for the save", elapsed)
+	}
+}
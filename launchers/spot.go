@@ -0,0 +1,61 @@
+package launchers
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// SpotReclaimedMessage is how the launcher tells Discord, and the dispatcher, that AWS took a spot instance back
+// once the game is saved. Relayed chat always starts with the player's name, so it can't say exactly this.
+const SpotReclaimedMessage = ":cloud_tornado: AWS is taking this spot instance back; the game is saved."
+
+const spotPollInterval = 5 * time.Second
+
+var spotReclaimed = make(chan struct{})
+
+// spotInstanceAction is what the metadata service says about an instance AWS is about to interrupt.
+type spotInstanceAction struct {
+	Action string
+	Time   time.Time
+}
+
+// watchSpotInterruption polls for the two minute warning AWS gives before reclaiming a spot instance, and then shuts
+// the server down the way it would when everyone leaves, saving first.
+func watchSpotInterruption(server Server) {
+	for {
+		time.Sleep(spotPollInterval)
+		action, found := fetchSpotInstanceAction()
+		if !found || (action.Action != "terminate" && action.Action != "stop") {
+			continue
+		}
+		log.Printf("Spot instance to %s at %v", action.Action, action.Time)
+		close(spotReclaimed)
+		sayInDiscord(":cloud_tornado: AWS wants this spot instance back; saving the game.")
+		server.Interrupt("AWS is reclaiming this server; saving now.")
+		return
+	}
+}
+
+// fetchSpotInstanceAction tells whether an interruption is scheduled; the metadata service answers 404 until then.
+func fetchSpotInstanceAction() (spotInstanceAction, bool) {
+	var action spotInstanceAction
+	token, err := imdsToken()
+	if err != nil {
+		return action, false
+	}
+	value, err := imdsGet(token, "spot/instance-action")
+	if err != nil || json.Unmarshal([]byte(value), &action) != nil {
+		return action, false
+	}
+	return action, true
+}
+
+func wasReclaimed() bool {
+	select {
+	case <-spotReclaimed:
+		return true
+	default:
+		return false
+	}
+}
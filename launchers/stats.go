@@ -0,0 +1,114 @@
+package launchers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SessionSummary is what happened during one session, kept at PREFIX/stats/<session>.json for the dispatcher.
+type SessionSummary struct {
+	Session     string
+	Start       time.Time
+	End         time.Time
+	PeakPlayers int
+	Spans       []PlayerSpan
+}
+
+// PlayerSpan is one stretch of a player being in the game.
+type PlayerSpan struct {
+	Player User
+	Joined time.Time
+	Left   time.Time
+}
+
+// sessionStats follows players joining and leaving.
+type sessionStats struct {
+	summary SessionSummary
+	online  map[User]time.Time
+}
+
+const statsPrefix = "stats/"
+
+func newSessionStats(now time.Time) *sessionStats {
+	return &sessionStats{
+		summary: SessionSummary{Session: sessionName(), Start: now},
+		online:  map[User]time.Time{},
+	}
+}
+
+func (stats *sessionStats) Record(line ParsedLine, now time.Time) {
+	switch line.Event {
+	case EventJoin:
+		if _, found := stats.online[line.Author]; !found {
+			stats.online[line.Author] = now
+		}
+		if len(stats.online) > stats.summary.PeakPlayers {
+			stats.summary.PeakPlayers = len(stats.online)
+		}
+	case EventLeave:
+		stats.leave(line.Author, now)
+	}
+}
+
+func (stats *sessionStats) leave(player User, now time.Time) {
+	joined, found := stats.online[player]
+	if !found {
+		return
+	}
+	delete(stats.online, player)
+	stats.summary.Spans = append(stats.summary.Spans, PlayerSpan{player, joined, now})
+}
+
+// ServerStopped counts everyone as gone, since nobody says goodbye when the server crashes.
+func (stats *sessionStats) ServerStopped(now time.Time) {
+	for player := range stats.online {
+		stats.leave(player, now)
+	}
+}
+
+func (stats *sessionStats) Finish(now time.Time) SessionSummary {
+	stats.ServerStopped(now)
+	stats.summary.End = now
+	return stats.summary
+}
+
+// PlayTimes adds up how long each player was in the game.
+func (summary SessionSummary) PlayTimes() map[User]time.Duration {
+	result := map[User]time.Duration{}
+	for _, span := range summary.Spans {
+		result[span.Player] += span.Left.Sub(span.Joined)
+	}
+	return result
+}
+
+// Describe is the summary said in Discord when the session ends.
+func (summary SessionSummary) Describe() string {
+	duration := summary.End.Sub(summary.Start).Round(time.Minute)
+	times := summary.PlayTimes()
+	if len(times) == 0 {
+		return fmt.Sprintf(":bar_chart: Session lasted %v; nobody played.", duration)
+	}
+	players := make([]User, 0, len(times))
+	for player := range times {
+		players = append(players, player)
+	}
+	sort.Slice(players, func(i, j int) bool { return times[players[i]] > times[players[j]] })
+	var parts []string
+	for _, player := range players {
+		parts = append(parts, fmt.Sprintf("%s %v", displayName(player), times[player].Round(time.Minute)))
+	}
+	return fmt.Sprintf(":bar_chart: Session lasted %v, with up to %d playing: %s.",
+		duration, summary.PeakPlayers, strings.Join(parts, ", "))
+}
+
+func uploadSessionSummary(summary SessionSummary) error {
+	buffer, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+	return s3upload(statsPrefix+summary.Session+".json", bytes.NewReader(buffer))
+}
@@ -0,0 +1,29 @@
+package launchers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSessionStatsCountsPlayTimeAcrossCrashes(t *testing.T) {
+	start := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	at := func(minutes int) time.Time { return start.Add(time.Duration(minutes) * time.Minute) }
+	stats := newSessionStats(start)
+	stats.Record(ParsedLine{Event: EventJoin, Author: "alice"}, at(1))
+	stats.Record(ParsedLine{Event: EventJoin, Author: "bob"}, at(2))
+	stats.Record(ParsedLine{Event: EventLeave, Author: "bob"}, at(12))
+	stats.ServerStopped(at(31)) // crash, alice never left
+	stats.Record(ParsedLine{Event: EventJoin, Author: "bob"}, at(40))
+	summary := stats.Finish(at(60))
+
+	if summary.PeakPlayers != 2 {
+		t.Errorf("peak is %d, want 2", summary.PeakPlayers)
+	}
+	times := summary.PlayTimes()
+	if times["alice"] != 30*time.Minute || times["bob"] != 30*time.Minute {
+		t.Errorf("play times are %v", times)
+	}
+	if !summary.End.Equal(at(60)) {
+		t.Errorf("ended at %v", summary.End)
+	}
+}
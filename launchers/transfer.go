@@ -0,0 +1,199 @@
+package launchers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// TransferProgress tells how far along a transfer to or from S3 is.
+type TransferProgress struct {
+	Name   string
+	Upload bool
+	Done   int64
+	Total  int64
+}
+
+// progressWriterAt writes a download into a file while keeping track of how much of its beginning is complete, which
+// is where a retry should resume from.
+type progressWriterAt struct {
+	file     *os.File
+	offset   int64
+	lock     sync.Mutex
+	progress TransferProgress
+}
+
+// progressReader counts what an upload has consumed.
+type progressReader struct {
+	reader   io.Reader
+	progress TransferProgress
+}
+
+const transferAttempts = 5
+const transferBackoff = 1 * time.Second
+
+// Sha256MetadataKey is the object metadata holding the SHA-256 of what was uploaded, as hex.
+const Sha256MetadataKey = "sha256"
+
+// onTransferProgress is told about every chunk of every transfer; Launch points it at Discord.
+var onTransferProgress = func(TransferProgress) {}
+
+func s3uploader() *manager.Uploader {
+	return manager.NewUploader(s3client)
+}
+
+func s3downloader() *manager.Downloader {
+	return manager.NewDownloader(s3client, func(downloader *manager.Downloader) {
+		downloader.Concurrency = 1 // so what we have is always a prefix we can resume from
+	})
+}
+
+func isNotFound(err error) bool {
+	var noSuchKey *types.NoSuchKey
+	var notFound *types.NotFound
+	return errors.As(err, &noSuchKey) || errors.As(err, &notFound)
+}
+
+func withRetries(what string, job func() error) error {
+	backoff := transferBackoff
+	for attempt := 1; ; attempt++ {
+		err := job()
+		if err == nil || attempt == transferAttempts {
+			return err
+		}
+		log.Printf("%s failed (attempt %d of %d), retrying in %v: %v", what, attempt, transferAttempts, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// s3downloadFile fetches an object into a file, resuming with byte ranges when an attempt fails midway, and checks
+// the result against the checksum recorded on upload. Tells false if there is no such object.
+func s3downloadFile(name, path string) (bool, error) {
+	key := aws.String(s3key(name))
+	head, err := s3client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: &envBucket, Key: key})
+	if isNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return true, err
+	}
+	partPath := path + ".part"
+	file, err := os.Create(partPath)
+	if err != nil {
+		return true, err
+	}
+	defer CloseDontCare(file)
+
+	writer := &progressWriterAt{file: file, progress: TransferProgress{Name: name, Total: head.ContentLength}}
+	err = withRetries("Downloading "+name, func() error {
+		input := s3.GetObjectInput{Bucket: &envBucket, Key: key, IfMatch: head.ETag}
+		writer.offset = writer.progress.Done
+		if writer.offset > 0 {
+			input.Range = aws.String(fmt.Sprintf("bytes=%d-", writer.offset))
+		}
+		_, err := s3downloader().Download(ctx, writer, &input)
+		if err == nil && writer.progress.Done != head.ContentLength {
+			err = fmt.Errorf("got %d bytes of %d", writer.progress.Done, head.ContentLength)
+		}
+		return err
+	})
+	if err != nil {
+		return true, err
+	}
+
+	if expected := head.Metadata[Sha256MetadataKey]; expected != "" {
+		hash, err := FileSha256(partPath)
+		if err != nil {
+			return true, err
+		}
+		if hash != expected {
+			_ = os.Remove(partPath)
+			return true, fmt.Errorf("downloaded %s has SHA-256 %s, expected %s", name, hash, expected)
+		}
+	}
+	return true, os.Rename(partPath, path)
+}
+
+// s3uploadFile sends a file in parallel parts, recording its checksum so downloads can be verified.
+func s3uploadFile(path, name string) error {
+	hash, err := FileSha256(path)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer CloseDontCare(file)
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	return withRetries("Uploading "+name, func() error {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		reader := &progressReader{file, TransferProgress{Name: name, Upload: true, Total: info.Size()}}
+		input := s3.PutObjectInput{
+			Bucket:   &envBucket,
+			Key:      aws.String(s3key(name)),
+			Body:     reader,
+			Metadata: map[string]string{Sha256MetadataKey: hash},
+		}
+		_, err := s3uploader().Upload(ctx, &input)
+		return err
+	})
+}
+
+// FileSha256 tells the SHA-256 of a file, as hex.
+func FileSha256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer CloseDontCare(file)
+	hash := sha256.New()
+	if _, err = io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func (writer *progressWriterAt) WriteAt(buffer []byte, offset int64) (int, error) {
+	offset += writer.offset
+	written, err := writer.file.WriteAt(buffer, offset)
+	writer.lock.Lock()
+	if offset <= writer.progress.Done && offset+int64(written) > writer.progress.Done {
+		writer.progress.Done = offset + int64(written)
+	}
+	progress := writer.progress
+	writer.lock.Unlock()
+	onTransferProgress(progress)
+	return written, err
+}
+
+func (reader *progressReader) Read(buffer []byte) (int, error) {
+	read, err := reader.reader.Read(buffer)
+	reader.progress.Done += int64(read)
+	onTransferProgress(reader.progress)
+	return read, err
+}
@@ -0,0 +1,107 @@
+package launchers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Version is a Factorio version as major, minor and patch; the build number saves carry is not relevant to us.
+type Version [3]uint16
+
+// factorioReleasesUrl is a variable so tests can stand in for factorio.com.
+var factorioReleasesUrl = "https://factorio.com/api/latest-releases"
+
+var factorioRegexpVersion = regexp.MustCompile(`^\d+\.\d+\.\d+$`)
+var errUnknownVersion = errors.New("expected stable, experimental or a version like 1.1.110")
+
+func ParseVersion(value string) (Version, error) {
+	var result Version
+	if !factorioRegexpVersion.MatchString(value) {
+		return result, errUnknownVersion
+	}
+	for i, part := range strings.Split(value, ".") {
+		number, err := strconv.ParseUint(part, 10, 16)
+		if err != nil {
+			return result, err
+		}
+		result[i] = uint16(number)
+	}
+	return result, nil
+}
+
+func (version Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", version[0], version[1], version[2])
+}
+
+func (version Version) Less(other Version) bool {
+	for i := range version {
+		if version[i] != other[i] {
+			return version[i] < other[i]
+		}
+	}
+	return false
+}
+
+// IsFactorioVersionRequest tells whether a FACTORIO_VERSION value is something ResolveFactorioVersion understands.
+func IsFactorioVersionRequest(value string) bool {
+	switch value {
+	case "", "latest", "stable", "experimental":
+		return true
+	}
+	return factorioRegexpVersion.MatchString(value)
+}
+
+// ResolveFactorioVersion turns "stable" (also "latest" or empty) and "experimental" into the current headless release
+// of that branch, using Factorio's public releases API. Explicit versions are returned as they are.
+func ResolveFactorioVersion(value string) (Version, error) {
+	var branch string
+	switch value {
+	case "", "latest", "stable":
+		branch = "stable"
+	case "experimental":
+		branch = "experimental"
+	default:
+		return ParseVersion(value)
+	}
+
+	httpClient := http.Client{Timeout: 10 * time.Second}
+	response, err := httpClient.Get(factorioReleasesUrl)
+	if err != nil {
+		return Version{}, err
+	}
+	defer CloseDontCare(response.Body)
+	if response.StatusCode != http.StatusOK {
+		return Version{}, fmt.Errorf("%s answered %s", factorioReleasesUrl, response.Status)
+	}
+	var releases map[string]map[string]string
+	err = json.NewDecoder(response.Body).Decode(&releases)
+	if err != nil {
+		return Version{}, err
+	}
+	version, found := releases[branch]["headless"]
+	if !found {
+		return Version{}, fmt.Errorf("no %s headless release listed", branch)
+	}
+	return ParseVersion(version)
+}
+
+// installedFactorioVersion reads the version of the game already unpacked in the game folder, if any.
+func installedFactorioVersion() (Version, error) {
+	buffer, err := os.ReadFile(factorioBaseInfoPath)
+	if err != nil {
+		return Version{}, err
+	}
+	var info struct{ Version string }
+	err = json.Unmarshal(buffer, &info)
+	if err != nil {
+		return Version{}, err
+	}
+	return ParseVersion(info.Version)
+}
@@ -0,0 +1,84 @@
+package launchers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// useTestReleases stands in for factorio.com's releases API, answering with the given status and body, and counts
+// requests.
+func useTestReleases(t *testing.T, status int, body string) *int32 {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		writer.WriteHeader(status)
+		_, _ = writer.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	previous := factorioReleasesUrl
+	factorioReleasesUrl = server.URL
+	t.Cleanup(func() { factorioReleasesUrl = previous })
+	return &requests
+}
+
+const testReleases = `{
+	"experimental": {"alpha": "2.0.15", "demo": "1.1.110", "expansion": "2.0.15", "headless": "2.0.15"},
+	"stable": {"alpha": "1.1.110", "demo": "1.1.110", "headless": "1.1.109"}
+}`
+
+func TestResolveFactorioVersion(t *testing.T) {
+	requests := useTestReleases(t, http.StatusOK, testReleases)
+	for value, expected := range map[string]string{
+		"":             "1.1.109",
+		"latest":       "1.1.109",
+		"stable":       "1.1.109",
+		"experimental": "2.0.15",
+		"1.0.0":        "1.0.0",
+	} {
+		version, err := ResolveFactorioVersion(value)
+		if err != nil {
+			t.Errorf("%q: %v", value, err)
+			continue
+		}
+		if version.String() != expected {
+			t.Errorf("%q resolved to %v, expected %s", value, version, expected)
+		}
+	}
+	if *requests != 4 {
+		t.Errorf("asked for releases %d times, expected 4 as pinned versions don't need to", *requests)
+	}
+}
+
+func TestResolveFactorioVersionFailures(t *testing.T) {
+	useTestReleases(t, http.StatusServiceUnavailable, "")
+	if _, err := ResolveFactorioVersion("stable"); err == nil {
+		t.Error("resolved with the API down")
+	}
+	useTestReleases(t, http.StatusOK, `{"stable": {"alpha": "1.1.110"}}`)
+	if _, err := ResolveFactorioVersion("experimental"); err == nil {
+		t.Error("resolved a branch that isn't listed")
+	}
+	if _, err := ResolveFactorioVersion("1.1"); err == nil {
+		t.Error("resolved a version without a patch number")
+	}
+}
+
+func TestIsFactorioVersionRequest(t *testing.T) {
+	for value, expected := range map[string]bool{
+		"":             true,
+		"stable":       true,
+		"latest":       true,
+		"experimental": true,
+		"1.1.110":      true,
+		"1.1":          false,
+		"v1.1.110":     false,
+		"1.1.110 ":     false,
+		"beta":         false,
+	} {
+		if IsFactorioVersionRequest(value) != expected {
+			t.Errorf("%q should be %v", value, expected)
+		}
+	}
+}